@@ -0,0 +1,250 @@
+package simplemdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// AuditOptions configures an audit log that appends every newly observed or
+// changed record to a JSONL file, for tracking what appears on a network over
+// time.
+type AuditOptions struct {
+	Path       string // file to append to
+	MaxSize    int64  // rotate when the file exceeds this many bytes; defaults to 10 MiB
+	MaxBackups int    // rotated files to keep as Path.1, Path.2, ...; defaults to 3
+}
+
+// auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"` // "new", "changed" or "goodbye"
+	Source string    `json:"source"`
+	Name   string    `json:"name"`
+	Type   string    `json:"type"`
+	Class  string    `json:"class"`
+	TTL    uint32    `json:"ttl"`
+	Flush  bool      `json:"flush"`
+	Data   string    `json:"data"`
+}
+
+// auditMaxTracked bounds the memory used to recognize already logged records;
+// past it the set is reset and records are logged again as they reappear.
+const auditMaxTracked = 100_000
+
+// auditQueueLen is how many entries may wait for the writer before new ones
+// are dropped; enough for a burst of announcements while the disk is slow.
+const auditQueueLen = 1024
+
+// auditLog is fed on the receive path, which must not block, so entries are
+// queued for a writer goroutine that encodes them and does the file I/O.
+type auditLog struct {
+	opts   AuditOptions
+	onDrop func(*dns.Msg) // nil if not set
+
+	mu     sync.Mutex
+	seen   map[string]struct{} // name/type/class/rdata already logged
+	unique map[string]string   // name/type/class -> rdata of the last cache-flush record
+
+	entries chan auditEntry
+	dropped atomic.Uint64
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	// owned by the writer goroutine
+	f    *os.File
+	size int64
+	err  error // from closing f
+}
+
+func openAuditLog(opts AuditOptions, onDrop func(*dns.Msg)) (*auditLog, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 10 << 20
+	}
+	if opts.MaxBackups <= 0 {
+		opts.MaxBackups = 3
+	}
+
+	a := &auditLog{
+		opts:    opts,
+		onDrop:  onDrop,
+		seen:    make(map[string]struct{}),
+		unique:  make(map[string]string),
+		entries: make(chan auditEntry, auditQueueLen),
+		done:    make(chan struct{}),
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	a.wg.Go(a.writer)
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	a.f, a.size = f, fi.Size()
+	return nil
+}
+
+// observe queues the records of msg that were not seen before for logging.
+// If the queue is full they are dropped and counted.
+func (a *auditLog) observe(msg *ReceivedMsg) {
+	var source string
+	if msg.Src != nil {
+		source = msg.Src.String()
+	}
+	now := time.Now()
+
+	var dropped bool
+	a.mu.Lock()
+	defer func() {
+		a.mu.Unlock()
+		if dropped && a.onDrop != nil {
+			a.onDrop(msg.Msg)
+		}
+	}()
+
+	for _, sec := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range sec {
+			h := rr.Header()
+			if h.Rrtype == dns.TypeOPT {
+				continue
+			}
+			flush := h.Class&cacheFlushBit != 0
+			class := h.Class &^ cacheFlushBit
+			data := strings.TrimPrefix(rr.String(), h.String())
+			rrset := fmt.Sprintf("%s/%d/%d", strings.ToLower(h.Name), h.Rrtype, class)
+
+			event := "new"
+			key := rrset + "/" + data
+			if h.Ttl == 0 {
+				// log the record as new if it is announced again
+				event = "goodbye"
+				delete(a.seen, key)
+				if a.unique[rrset] == data {
+					delete(a.unique, rrset)
+				}
+			} else {
+				if _, ok := a.seen[key]; ok {
+					continue
+				}
+				if len(a.seen) >= auditMaxTracked {
+					clear(a.seen)
+					clear(a.unique)
+				}
+				a.seen[key] = struct{}{}
+				if flush {
+					if prev, ok := a.unique[rrset]; ok && prev != data {
+						event = "changed"
+					}
+					a.unique[rrset] = data
+				}
+			}
+
+			e := auditEntry{
+				Time:   now,
+				Event:  event,
+				Source: source,
+				Name:   h.Name,
+				Type:   dns.Type(h.Rrtype).String(),
+				Class:  dns.Class(class).String(),
+				TTL:    h.Ttl,
+				Flush:  flush,
+				Data:   data,
+			}
+			select {
+			case a.entries <- e:
+			default:
+				// log it when it shows up again
+				delete(a.seen, key)
+				a.dropped.Add(1)
+				dropped = true
+			}
+		}
+	}
+}
+
+// writer writes queued entries until close, then the ones still queued, and
+// closes the file.
+func (a *auditLog) writer() {
+	for {
+		select {
+		case e := <-a.entries:
+			a.write(e)
+		case <-a.done:
+			for {
+				select {
+				case e := <-a.entries:
+					a.write(e)
+				default:
+					if a.f != nil {
+						a.err = a.f.Close()
+						a.f = nil
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (a *auditLog) write(e auditEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	if a.size+int64(len(b)) > a.opts.MaxSize && a.size > 0 {
+		if err := a.rotate(); err != nil {
+			logger.Warn("failed to rotate audit log", slog.Any("error", err))
+		}
+	}
+	if a.f == nil {
+		// a previous rotation failed half way; try to recover
+		if err := a.open(); err != nil {
+			return
+		}
+	}
+	n, err := a.f.Write(b)
+	a.size += int64(n)
+	if err != nil {
+		logger.Warn("failed to write audit log", slog.Any("error", err))
+	}
+}
+
+// rotate shifts Path.N-1 -> Path.N, ..., Path -> Path.1 and reopens Path.
+func (a *auditLog) rotate() error {
+	a.f.Close()
+	a.f = nil
+	for i := a.opts.MaxBackups; i > 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", a.opts.Path, i-1), fmt.Sprintf("%s.%d", a.opts.Path, i))
+	}
+	if err := os.Rename(a.opts.Path, a.opts.Path+".1"); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+// close writes out the queued entries and closes the file. Entries observed
+// afterwards are dropped.
+func (a *auditLog) close() error {
+	close(a.done)
+	a.wg.Wait()
+	return a.err
+}
@@ -0,0 +1,104 @@
+package simplemdns
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func readAuditEntries(t *testing.T, path string) []auditEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []auditEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("bad line %q: %v", sc.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a, err := openAuditLog(AuditOptions{Path: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 5353}
+	response := func(ip string, ttl uint32) *ReceivedMsg {
+		m := new(dns.Msg)
+		m.Response = true
+		m.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlushBit, Ttl: ttl},
+			A:   net.ParseIP(ip),
+		}}
+		return &ReceivedMsg{Msg: m, Src: from}
+	}
+	a.observe(response("192.168.1.10", 120))
+	a.observe(response("192.168.1.10", 120)) // repeated announcement
+	a.observe(response("192.168.1.20", 120))
+	a.observe(response("192.168.1.20", 0))
+	a.observe(response("192.168.1.20", 120)) // back after the goodbye
+	if err := a.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readAuditEntries(t, path)
+	want := []struct{ event, data string }{
+		{"new", "192.168.1.10"},
+		{"changed", "192.168.1.20"},
+		{"goodbye", "192.168.1.20"},
+		{"new", "192.168.1.20"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		if entries[i].Event != w.event || entries[i].Data != w.data || entries[i].Source != from.String() {
+			t.Errorf("entry %d: got %+v, want %s of %s", i, entries[i], w.event, w.data)
+		}
+	}
+}
+
+func TestAuditLogDropsWhenBehind(t *testing.T) {
+	// no writer draining a one-entry queue stands in for a stuck disk
+	var drops int
+	a := &auditLog{
+		onDrop:  func(*dns.Msg) { drops++ },
+		seen:    make(map[string]struct{}),
+		unique:  make(map[string]string),
+		entries: make(chan auditEntry, 1),
+	}
+
+	m := new(dns.Msg)
+	m.Response = true
+	for _, ip := range []string{"192.168.1.1", "192.168.1.2", "192.168.1.3"} {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120},
+			A:   net.ParseIP(ip),
+		})
+	}
+	a.observe(&ReceivedMsg{Msg: m})
+	if got := a.dropped.Load(); got != 2 {
+		t.Errorf("dropped %d records, want 2", got)
+	}
+	if drops != 1 {
+		t.Errorf("onDrop called %d times, want 1", drops)
+	}
+	if len(a.seen) != 1 {
+		t.Errorf("%d records marked seen, want only the queued one", len(a.seen))
+	}
+}
@@ -0,0 +1,194 @@
+package simplemdns
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// goodbyeGrace is how long records stay cached after a goodbye or a cache
+// flush, so that a quick re-announcement or the rest of a split rrset is not
+// lost (RFC 6762 §10.1 and §10.2).
+const goodbyeGrace = time.Second
+
+type cacheKey struct {
+	name   string // lower-cased
+	rrtype uint16
+	class  uint16 // without the cache-flush bit
+}
+
+type cacheEntry struct {
+	rr       dns.RR // class without the cache-flush bit
+	received time.Time
+	expires  time.Time
+}
+
+// cache holds the records received in responses until their TTL runs out.
+type cache struct {
+	max int
+
+	mu      sync.Mutex
+	entries map[cacheKey][]*cacheEntry
+	size    int
+}
+
+func newCache(max int) *cache {
+	return &cache{max: max, entries: make(map[cacheKey][]*cacheEntry)}
+}
+
+func keyOf(h *dns.RR_Header) cacheKey {
+	return cacheKey{strings.ToLower(h.Name), h.Rrtype, h.Class &^ cacheFlushBit}
+}
+
+// add caches the records of msg, a response. A zero TTL is a goodbye and
+// expires the record in a second. A record with the cache-flush bit set
+// replaces the records of its name, type and class received more than a
+// second ago, which expire in a second.
+func (c *cache) add(msg *dns.Msg, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flushed := make(map[cacheKey]bool)
+	for _, sec := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range sec {
+			h := rr.Header()
+			if h.Rrtype == dns.TypeOPT {
+				continue
+			}
+			key := keyOf(h)
+
+			if h.Class&cacheFlushBit != 0 && !flushed[key] {
+				flushed[key] = true
+				for _, e := range c.entries[key] {
+					if now.Sub(e.received) > goodbyeGrace && e.expires.After(now.Add(goodbyeGrace)) {
+						e.expires = now.Add(goodbyeGrace)
+					}
+				}
+			}
+
+			rr = dns.Copy(rr)
+			rr.Header().Class &^= cacheFlushBit
+			expires := now.Add(time.Duration(h.Ttl) * time.Second)
+			if h.Ttl == 0 {
+				expires = now.Add(goodbyeGrace)
+			}
+
+			if e := c.find(key, rr); e != nil {
+				e.rr, e.received, e.expires = rr, now, expires
+				continue
+			}
+			if h.Ttl == 0 {
+				continue // nothing to say goodbye to
+			}
+			c.entries[key] = append(c.entries[key], &cacheEntry{rr: rr, received: now, expires: expires})
+			c.size++
+		}
+	}
+
+	if c.size > c.max {
+		c.prune(now)
+	}
+}
+
+// find returns the entry holding the same record as rr. c.mu must be held.
+func (c *cache) find(key cacheKey, rr dns.RR) *cacheEntry {
+	for _, e := range c.entries[key] {
+		if sameRecord(e.rr, rr) {
+			return e
+		}
+	}
+	return nil
+}
+
+// lookup returns the unexpired records answering q, with their TTLs set to
+// the time they have left. Records said goodbye to are already withdrawn.
+func (c *cache) lookup(q dns.Question, now time.Time) []dns.RR {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	class := q.Qclass &^ cacheFlushBit
+	var keys []cacheKey
+	if q.Qtype == dns.TypeANY || class == dns.ClassANY {
+		for key := range c.entries {
+			if key.name == strings.ToLower(q.Name) &&
+				(q.Qtype == dns.TypeANY || key.rrtype == q.Qtype) &&
+				(class == dns.ClassANY || key.class == class) {
+				keys = append(keys, key)
+			}
+		}
+	} else {
+		keys = []cacheKey{{strings.ToLower(q.Name), q.Qtype, class}}
+	}
+
+	var rrs []dns.RR
+	for _, key := range keys {
+		for _, e := range c.entries[key] {
+			left := e.expires.Sub(now)
+			if e.rr.Header().Ttl == 0 || left <= 0 {
+				continue
+			}
+			rr := dns.Copy(e.rr)
+			rr.Header().Ttl = uint32((left + time.Second - 1) / time.Second)
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+// knownAnswers returns the records answering q that are still past less
+// than half their TTL, with their TTLs set to the time they have left. These
+// may go in the answer section of a query for q (RFC 6762 §7.1). Records
+// said goodbye to, kept a moment with a zero TTL, are not known answers.
+func (c *cache) knownAnswers(q dns.Question, now time.Time) []dns.RR {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var rrs []dns.RR
+	for _, e := range c.entries[cacheKey{strings.ToLower(q.Name), q.Qtype, q.Qclass &^ cacheFlushBit}] {
+		ttl := e.rr.Header().Ttl
+		left := e.expires.Sub(now)
+		if ttl == 0 || left < time.Duration(ttl)*time.Second/2 {
+			continue
+		}
+		rr := dns.Copy(e.rr)
+		rr.Header().Ttl = uint32(left / time.Second)
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+// prune drops expired records and, if the cache is still over its limit,
+// the records closest to expiry, leaving some room so that the next few
+// additions do not prune again. c.mu must be held.
+func (c *cache) prune(now time.Time) {
+	var all []*cacheEntry
+	for _, es := range c.entries {
+		all = append(all, es...)
+	}
+	slices.SortFunc(all, func(a, b *cacheEntry) int { return a.expires.Compare(b.expires) })
+	cutoff := now
+	if over := len(all) - (c.max - c.max/10); over > 0 && all[over-1].expires.After(now) {
+		cutoff = all[over-1].expires
+	}
+
+	for key, es := range c.entries {
+		kept := slices.DeleteFunc(es, func(e *cacheEntry) bool { return !e.expires.After(cutoff) })
+		c.size -= len(es) - len(kept)
+		if len(kept) == 0 {
+			delete(c.entries, key)
+		} else {
+			c.entries[key] = kept
+		}
+	}
+}
+
+// len returns the number of cached records, including expired ones not yet
+// pruned.
+func (c *cache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
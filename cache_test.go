@@ -0,0 +1,212 @@
+package simplemdns
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func cacheA(name, ip string, ttl uint32, flush bool) *dns.A {
+	class := uint16(dns.ClassINET)
+	if flush {
+		class |= cacheFlushBit
+	}
+	return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: class, Ttl: ttl}, A: net.ParseIP(ip)}
+}
+
+func cacheResponse(rrs ...dns.RR) *dns.Msg {
+	m := new(dns.Msg)
+	m.Response = true
+	m.Answer = rrs
+	return m
+}
+
+func TestCacheKnownAnswers(t *testing.T) {
+	q := dns.Question{Name: "host.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET | cacheFlushBit}
+	t0 := time.Now()
+
+	tests := []struct {
+		name  string
+		setup func(c *cache)
+		at    time.Duration // after t0
+		want  int
+	}{
+		{"fresh", func(c *cache) { c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, false)), t0) }, 30 * time.Second, 1},
+		{"exactly half left", func(c *cache) { c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, false)), t0) }, 60 * time.Second, 1},
+		{"less than half left", func(c *cache) { c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, false)), t0) }, 61 * time.Second, 0},
+		{"goodbye", func(c *cache) {
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, false)), t0)
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 0, false)), t0)
+		}, 0, 0},
+		{"flushed", func(c *cache) {
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, true)), t0)
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.2", 120, true)), t0.Add(2*time.Second))
+		}, 2 * time.Second, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCache(16)
+			tt.setup(c)
+			got := c.knownAnswers(q, t0.Add(tt.at))
+			if len(got) != tt.want {
+				t.Fatalf("got %v, want %d records", got, tt.want)
+			}
+			for _, rr := range got {
+				if rr.Header().Ttl == 0 {
+					t.Errorf("known answer with a zero TTL: %v", rr)
+				}
+				if rr.Header().Class&cacheFlushBit != 0 {
+					t.Errorf("known answer with the cache-flush bit: %v", rr)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheLookup(t *testing.T) {
+	t0 := time.Now()
+	c := newCache(16)
+	c.add(cacheResponse(
+		cacheA("host.local.", "10.0.0.1", 120, false),
+		&dns.AAAA{Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 120}, AAAA: net.ParseIP("fe80::1")},
+		cacheA("short.local.", "10.0.0.2", 1, false),
+	), t0)
+
+	tests := []struct {
+		name string
+		q    dns.Question
+		at   time.Duration // after t0
+		want []string      // record data
+		ttl  uint32        // of every record, if set
+	}{
+		{"exact", dns.Question{Name: "host.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}, 0, []string{"10.0.0.1"}, 120},
+		{"case-insensitive", dns.Question{Name: "HOST.Local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}, 0, []string{"10.0.0.1"}, 0},
+		{"unicast-response bit", dns.Question{Name: "host.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET | cacheFlushBit}, 0, []string{"10.0.0.1"}, 0},
+		{"any type", dns.Question{Name: "host.local.", Qtype: dns.TypeANY, Qclass: dns.ClassINET}, 0, []string{"10.0.0.1", "fe80::1"}, 0},
+		{"any class", dns.Question{Name: "host.local.", Qtype: dns.TypeAAAA, Qclass: dns.ClassANY}, 0, []string{"fe80::1"}, 0},
+		{"other type", dns.Question{Name: "host.local.", Qtype: dns.TypeTXT, Qclass: dns.ClassINET}, 0, nil, 0},
+		{"ttl counts down", dns.Question{Name: "host.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}, 30 * time.Second, []string{"10.0.0.1"}, 90},
+		{"ttl rounds up", dns.Question{Name: "host.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}, 500 * time.Millisecond, []string{"10.0.0.1"}, 120},
+		{"expired", dns.Question{Name: "short.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}, time.Second, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.lookup(tt.q, t0.Add(tt.at))
+			var data []string
+			for _, rr := range got {
+				switch rr := rr.(type) {
+				case *dns.A:
+					data = append(data, rr.A.String())
+				case *dns.AAAA:
+					data = append(data, rr.AAAA.String())
+				}
+				if tt.ttl != 0 && rr.Header().Ttl != tt.ttl {
+					t.Errorf("%v: got TTL %d, want %d", rr, rr.Header().Ttl, tt.ttl)
+				}
+			}
+			slices.Sort(data)
+			if !slices.Equal(data, tt.want) {
+				t.Errorf("got %v, want %v", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheAdd(t *testing.T) {
+	q := dns.Question{Name: "host.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	t0 := time.Now()
+
+	tests := []struct {
+		name  string
+		setup func(c *cache)
+		at    time.Duration // after t0
+		want  []string
+		size  int
+	}{
+		{"duplicate refreshes", func(c *cache) {
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 10, false)), t0)
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 10, false)), t0.Add(5*time.Second))
+		}, 12 * time.Second, []string{"10.0.0.1"}, 1},
+		{"shared records accumulate", func(c *cache) {
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, false)), t0)
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.2", 120, false)), t0.Add(2*time.Second))
+		}, 4 * time.Second, []string{"10.0.0.1", "10.0.0.2"}, 2},
+		{"goodbye withdraws at once", func(c *cache) {
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, false)), t0)
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 0, false)), t0)
+		}, 500 * time.Millisecond, nil, 1},
+		{"reannounced after goodbye", func(c *cache) {
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, false)), t0)
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 0, false)), t0)
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, false)), t0.Add(500*time.Millisecond))
+		}, 2 * time.Second, []string{"10.0.0.1"}, 1},
+		{"goodbye expires", func(c *cache) {
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, false)), t0)
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 0, false)), t0)
+		}, time.Second, nil, 1},
+		{"goodbye for unknown record", func(c *cache) {
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 0, false)), t0)
+		}, 0, nil, 0},
+		{"flush replaces older records", func(c *cache) {
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, true)), t0)
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.2", 120, true)), t0.Add(2*time.Second))
+		}, 3 * time.Second, []string{"10.0.0.2"}, 2},
+		{"flush keeps the rest of a split rrset", func(c *cache) {
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, true)), t0)
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.2", 120, true)), t0.Add(500*time.Millisecond))
+		}, 3 * time.Second, []string{"10.0.0.1", "10.0.0.2"}, 2},
+		{"flush within one message", func(c *cache) {
+			c.add(cacheResponse(cacheA("host.local.", "10.0.0.1", 120, true), cacheA("host.local.", "10.0.0.2", 120, true)), t0)
+		}, 3 * time.Second, []string{"10.0.0.1", "10.0.0.2"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCache(16)
+			tt.setup(c)
+			var got []string
+			for _, rr := range c.lookup(q, t0.Add(tt.at)) {
+				got = append(got, rr.(*dns.A).A.String())
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if c.len() != tt.size {
+				t.Errorf("got %d cached records, want %d", c.len(), tt.size)
+			}
+		})
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	t0 := time.Now()
+	c := newCache(10)
+	c.add(cacheResponse(cacheA("gone.local.", "10.0.0.1", 1, false)), t0)
+
+	// eleven more records, the nth living n minutes, push the cache over
+	// its limit two seconds later
+	var rrs []dns.RR
+	for i := range 11 {
+		rrs = append(rrs, cacheA(fmt.Sprintf("host%d.local.", i+1), "10.0.0.1", uint32(60*(i+1)), false))
+	}
+	c.add(cacheResponse(rrs...), t0.Add(2*time.Second))
+
+	// pruning leaves a tenth of the limit free
+	if c.len() != 9 {
+		t.Fatalf("got %d cached records, want 9", c.len())
+	}
+	for i, want := range []bool{false, false, false, true, true, true, true, true, true, true, true, true} {
+		name := "gone.local."
+		if i > 0 {
+			name = fmt.Sprintf("host%d.local.", i)
+		}
+		got := len(c.lookup(dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}, t0.Add(2*time.Second))) > 0
+		if got != want {
+			t.Errorf("%s cached: %v, want %v", name, got, want)
+		}
+	}
+}
@@ -3,26 +3,92 @@ package simplemdns
 import (
 	"context"
 	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/miekg/dns"
 
 	"github.com/oosawy/simplemdns/internal/transport"
 )
 
+// ErrPassive is returned when trying to send from a client in passive mode.
+var ErrPassive = errors.New("client is passive and does not transmit")
+
 // ClientOptions controls how the client creates its transport.
 type ClientOptions struct {
-	IPVersion      transport.IPVersion
+	IPVersion      transport.IPVersion // zero to detect from the addresses of the interfaces in use, and to add a family once they get addresses of it
 	BindTo         transport.BindStrategy
-	Interfaces     []net.Interface // nil or empty for all available multicast interfaces
-	UDPRecvBufSize int             // in bytes; should be at least 1500; will be set to 1500 if less
-	MsgsChBufSize  int             // msgs drop when full
+	Interfaces     []net.Interface      // nil or empty for all available multicast interfaces
+	IncludeVirtual bool                 // when Interfaces is empty, also use docker/veth/tun/VM interfaces, which are skipped by default
+	AcceptIfaces   []int                // if non-empty, only messages received on these interface indices are delivered
+	UDPRecvBufSize int                  // in bytes; should be at least 1500; will be set to 1500 if less
+	MsgsChBufSize  int                  // msgs drop when full
+	WriteTimeout   time.Duration        // per-send deadline; defaults to 1s, negative disables
+	StallTimeout   time.Duration        // receive stall detection threshold; defaults to 30s, negative disables
+	LogMessages    bool                 // log every sent/received message in full (questions and records) at Debug
+	PacketDump     io.Writer            // if set, hex dumps of raw sent/received packets are written here
+	Compliance     transport.Compliance // Lenient (default) or Strict RFC 6762 checking of received traffic
+	MaxInFlight    int                  // max concurrent QueryFirst/QueryMsg calls; more wait their turn; zero for no limit
+	CoalesceWindow time.Duration        // merge questions of queries started within this window into one packet; zero disables
+	Passive        bool                 // listen only: never transmit, queries just wait for matching traffic; binds the mDNS port unless BindTo is set
+	BestEffort     bool                 // keep going with a warning if no multicast group can be joined, instead of failing NewClient
+	CacheSize      int                  // max records kept in the answer cache; defaults to 4096, negative disables caching
+	StaticIfaces   bool                 // don't follow interfaces coming up, going down or changing addresses after NewClient
+
+	// LocalAddr binds the socket to one local address instead of using BindTo,
+	// e.g. 192.168.1.10:5353 on a host where only that address should source
+	// mDNS traffic. Only that address's family is used, and by default only its
+	// interface is joined. Note that on Linux a socket bound to a unicast
+	// address does not receive multicast, so this suits sending queries and
+	// receiving unicast replies rather than listening to the group.
+	LocalAddr *net.UDPAddr
+
+	// SharePort binds the mDNS port alongside a system daemon such as
+	// avahi-daemon or mDNSResponder instead of failing with PortInUseError.
+	// It works if the daemon allowed reuse too (SO_REUSEADDR, and
+	// SO_REUSEPORT except on Windows), as both do; on Linux, SO_REUSEPORT
+	// also requires the same user. Multicast reaches every socket on the
+	// port, but unicast sent to port 5353 is delivered to only one of them,
+	// which may be the daemon's.
+	SharePort bool
+
+	// Broker enables sharing port 5353 between simplemdns processes on one
+	// host. The first client binds the port and relays packets over a Unix
+	// socket at this path; later clients connect to it instead of binding.
+	// BindTo is ignored in broker mode. If the owning process exits, peers
+	// get an error on Errors and stop receiving.
+	Broker string
+
+	// Audit, if set, appends every newly observed or changed record to a
+	// rotating JSONL file.
+	Audit *AuditOptions
+
+	// OnSpoof, if set, is called when a unique (cache-flush) record is
+	// announced by a different source than the one that announced it first,
+	// within the first announcement's TTL. See SpoofEvent for caveats.
+	// RejectSpoofed additionally drops such records before they reach
+	// subscribers. Either enables the tracking; otherwise it costs nothing.
+	OnSpoof       func(SpoofEvent)
+	RejectSpoofed bool
+
+	// OnDrop, if set, is called whenever a received message is dropped because
+	// the transport or a subscriber channel is full, or its records are left
+	// out of the audit log because the log's writer fell behind. It runs on
+	// the receive path and must not block.
+	OnDrop func(kind DropKind, msg *dns.Msg)
 }
 
 func (o ClientOptions) withDefaults() ClientOptions {
-	if o.IPVersion == 0 {
-		o.IPVersion = transport.IPv4And6
+	if o.BindTo == 0 && o.Passive {
+		// other hosts' multicast traffic only reaches sockets on the mDNS port
+		o.BindTo = transport.BindMDNSPort
 	}
 	if o.BindTo == 0 {
 		// TODO: currently, works as simple resolver by default.
@@ -40,6 +106,24 @@ func (o ClientOptions) withDefaults() ClientOptions {
 		o.MsgsChBufSize = 32
 	}
 
+	if o.WriteTimeout == 0 {
+		// A healthy interface accepts a datagram almost immediately; anything
+		// close to a second means the interface is wedged.
+		o.WriteTimeout = time.Second
+	}
+
+	if o.StallTimeout == 0 {
+		// Our own multicasts loop back within milliseconds, so silence for this
+		// long after a send means the socket is dead, not that the network is quiet.
+		o.StallTimeout = 30 * time.Second
+	}
+
+	if o.CacheSize == 0 {
+		// A busy home network announces a few hundred records; this leaves
+		// plenty of room while bounding what a flood of announcements costs.
+		o.CacheSize = 4096
+	}
+
 	if o.UDPRecvBufSize < 1500 {
 		o.UDPRecvBufSize = 1500
 	}
@@ -52,9 +136,25 @@ type client struct {
 
 	closeOnce sync.Once
 
-	subscribers     []chan *dns.Msg
+	// subscribers is replaced wholesale on every change (copy-on-write) so the
+	// broadcaster can fan out without taking subMu; subMu serializes writers.
+	subscribers     atomic.Pointer[[]chan *ReceivedMsg]
 	subMu           sync.Mutex
 	broadcasterOnce sync.Once
+
+	subDropped atomic.Uint64
+	onDrop     func(DropKind, *dns.Msg)
+
+	inFlight chan struct{} // semaphore limiting concurrent queries; nil for no limit
+	coalesce *coalescer    // nil if coalescing is disabled
+	passive  bool
+	audit    *auditLog   // nil if auditing is disabled
+	spoof    *spoofGuard // nil if spoof tracking is disabled
+	cache    *cache      // nil if caching is disabled
+
+	firstAnswer   latencyHistogram
+	queryComplete latencyHistogram
+	queryFailures atomic.Uint64
 }
 
 // NewClient creates a new client using provided ClientOptions. Accepts zero or
@@ -67,88 +167,265 @@ func NewClient(opts ...ClientOptions) (*client, error) {
 	}
 	o = o.withDefaults()
 
-	t, err := transport.New(transport.Options{
+	topts := transport.Options{
 		IPVersion:      o.IPVersion,
 		BindTo:         o.BindTo,
+		LocalAddr:      o.LocalAddr,
 		JoinIfaces:     o.Interfaces,
+		IncludeVirtual: o.IncludeVirtual,
+		AcceptIfaces:   o.AcceptIfaces,
 		UDPRecvBufSize: o.UDPRecvBufSize,
 		MsgsChBufSize:  o.MsgsChBufSize,
-	})
+		WriteTimeout:   o.WriteTimeout,
+		StallTimeout:   o.StallTimeout,
+		LogMessages:    o.LogMessages,
+		PacketDump:     o.PacketDump,
+		Broker:         o.Broker,
+		Compliance:     o.Compliance,
+		BestEffort:     o.BestEffort,
+		SharePort:      o.SharePort,
+
+		WatchInterfaces: !o.StaticIfaces,
+	}
+	if o.OnDrop != nil {
+		topts.OnDrop = func(msg *dns.Msg) { o.OnDrop(DropTransport, msg) }
+	}
+
+	var spoof *spoofGuard
+	if o.OnSpoof != nil || o.RejectSpoofed {
+		spoof = newSpoofGuard(o.OnSpoof, o.RejectSpoofed)
+		topts.Filter = spoof.check
+	}
+
+	c := &client{onDrop: o.OnDrop, passive: o.Passive, spoof: spoof}
+	if o.CacheSize > 0 {
+		c.cache = newCache(o.CacheSize)
+	}
+	if o.Audit != nil {
+		var onDrop func(*dns.Msg)
+		if o.OnDrop != nil {
+			onDrop = func(msg *dns.Msg) { o.OnDrop(DropAudit, msg) }
+		}
+		a, err := openAuditLog(*o.Audit, onDrop)
+		if err != nil {
+			return nil, err
+		}
+		c.audit = a
+	}
+	if c.cache != nil || c.audit != nil {
+		topts.Observe = c.observe
+	}
+
+	t, err := transport.New(topts)
 	if err != nil {
+		if c.audit != nil {
+			c.audit.close()
+		}
 		return nil, err
 	}
+	c.t = t
 
-	return &client{t: t}, nil
+	if o.MaxInFlight > 0 {
+		c.inFlight = make(chan struct{}, o.MaxInFlight)
+	}
+	if o.CoalesceWindow > 0 {
+		c.coalesce = newCoalescer(o.CoalesceWindow, c.Query)
+	}
+	return c, nil
 }
 
 func (c *client) Close() (err error) {
 	c.closeOnce.Do(func() {
 		err = c.t.Close()
-
-		c.subMu.Lock()
-		for _, sub := range c.subscribers {
-			close(sub)
+		c.closeSubscribers()
+		if c.audit != nil {
+			err = errors.Join(err, c.audit.close())
 		}
-		c.subscribers = nil
-		c.subMu.Unlock()
 	})
 	return
 }
 
+// Errors returns a channel of asynchronous transport errors, such as a
+// *StallError when a receive loop stops delivering packets. Errors are dropped
+// if the channel is not drained. The channel is closed when the client is closed.
+func (c *client) Errors() <-chan error {
+	return c.t.Errors()
+}
+
 // Subscribe returns a new subscriber channel that will be closed when the client is closed.
-func (c *client) Subscribe() <-chan *dns.Msg {
-	ch := make(chan *dns.Msg, 32)
+// Each message comes with its sender, so a reply can go straight back to it,
+// and the interface it arrived on.
+func (c *client) Subscribe() <-chan *ReceivedMsg {
+	ch := make(chan *ReceivedMsg, 32)
 
 	c.subMu.Lock()
-	c.subscribers = append(c.subscribers, ch)
+	subs := c.loadSubscribers()
+	next := make([]chan *ReceivedMsg, len(subs), len(subs)+1)
+	copy(next, subs)
+	next = append(next, ch)
+	c.subscribers.Store(&next)
 	c.subMu.Unlock()
 
 	c.broadcasterOnce.Do(func() {
 		go func() {
 			for msg := range c.t.Messages() {
-				c.subMu.Lock()
-				subs := make([]chan *dns.Msg, len(c.subscribers))
-				copy(subs, c.subscribers)
-				c.subMu.Unlock()
-				for _, sub := range subs {
+				for _, sub := range c.loadSubscribers() {
 					select {
 					case sub <- msg:
 					default:
 						// drop if subscriber channel is full
+						c.subDropped.Add(1)
+						if c.onDrop != nil {
+							c.onDrop(DropSubscriber, msg.Msg)
+						}
 					}
 				}
 			}
 			// when t.Messages() is closed, close all subscribers
-			c.subMu.Lock()
-			for _, sub := range c.subscribers {
-				close(sub)
-			}
-			c.subscribers = nil
-			c.subMu.Unlock()
+			c.closeSubscribers()
 		}()
 	})
 
 	return ch
 }
 
+// unsubscribe removes ch from the subscribers. ch is not closed, as the
+// broadcaster may be about to send to it; it is simply no longer fed.
+func (c *client) unsubscribe(ch <-chan *ReceivedMsg) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	subs := c.loadSubscribers()
+	i := slices.IndexFunc(subs, func(sub chan *ReceivedMsg) bool { return sub == ch })
+	if i < 0 {
+		return // closed meanwhile
+	}
+	next := slices.Delete(slices.Clone(subs), i, i+1)
+	c.subscribers.Store(&next)
+}
+
+// loadSubscribers returns the current subscriber snapshot. The returned slice
+// must not be modified.
+func (c *client) loadSubscribers() []chan *ReceivedMsg {
+	if p := c.subscribers.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+func (c *client) closeSubscribers() {
+	c.subMu.Lock()
+	for _, sub := range c.loadSubscribers() {
+		close(sub)
+	}
+	c.subscribers.Store(nil)
+	c.subMu.Unlock()
+}
+
+// observe is the transport's Observe hook: it feeds every received message
+// to the audit log and the answers to the cache.
+func (c *client) observe(msg *ReceivedMsg) {
+	if c.audit != nil {
+		c.audit.observe(msg)
+	}
+	if c.cache != nil && carriesAnswers(msg.Msg) {
+		c.cache.add(msg.Msg, time.Now())
+	}
+}
+
+// CachedLookup returns the records answering question that were received
+// recently enough that their TTL has not run out, with the TTL set to the
+// seconds left; nil if there are none. A question of type or class ANY
+// returns every matching record. Nothing is sent.
+func (c *client) CachedLookup(question dns.Question) []dns.RR {
+	if c.cache == nil {
+		return nil
+	}
+	return c.cache.lookup(question, time.Now())
+}
+
+// SetInterfaces replaces the set of interfaces the client joins the mDNS
+// group on and sends queries through, e.g. after a VPN came up. Groups are
+// left on interfaces no longer in the set. It is not supported through a
+// Broker, which manages the interfaces itself.
+func (c *client) SetInterfaces(ifaces []net.Interface) error {
+	return c.t.SetInterfaces(ifaces)
+}
+
+// SyscallConns returns the raw connections of the client's IPv4 or IPv6
+// sockets, one per interface, for socket options this package does not
+// wrap, such as attaching a BPF filter. The sockets stay owned by the
+// client: do not close them, and expect reads through them to race with the
+// client's own. Sockets opened later by SetInterfaces are not included. It
+// is not available through a Broker.
+func (c *client) SyscallConns(family transport.IPVersion) ([]syscall.RawConn, error) {
+	return c.t.SyscallConns(family)
+}
+
 // TODO: accept ch to send responses, and a context to cancel
 // Query sends a dns.Msg via the transport.
 func (c *client) Query(msg *dns.Msg) error {
+	if c.passive {
+		return ErrPassive
+	}
 	return c.t.SendMsg(msg)
 }
 
-// QueryFirst sends a query and waits for the first matching answer.
+// QueryFirst returns a cached answer to question if there is one, and
+// otherwise sends a query and waits for the first matching answer,
+// retransmitting the question as QueryOptions describes.
 // Note: This method behaves like an RFC one-shot query, but uses mDNS (multicast)
 // rather than unicast. It exists for convenience and may be deprecated in the future.
-func (c *client) QueryFirst(ctx context.Context, question dns.Question) (dns.RR, error) {
-	msg := new(dns.Msg)
-	msg.Question = []dns.Question{question}
+func (c *client) QueryFirst(ctx context.Context, question dns.Question, opts ...QueryOptions) (dns.RR, error) {
+	for _, rr := range c.CachedLookup(question) {
+		if answers(rr, question) {
+			return rr, nil
+		}
+	}
+	resp, err := c.QueryMsg(ctx, question, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, ans := range resp.Answer {
+		if answers(ans, question) {
+			return ans, nil
+		}
+	}
+	return nil, errors.New("no matching answer") // unreachable: QueryMsg only returns answering messages
+}
+
+// QueryMsg sends a query and waits for the first response that answers it,
+// returning the whole message so callers can inspect the other answers, the
+// authority and additional sections, and the cache-flush bits, along with the
+// sender and the interface it came in on. The question is retransmitted as
+// QueryOptions describes until then.
+func (c *client) QueryMsg(ctx context.Context, question dns.Question, opts ...QueryOptions) (resp *ReceivedMsg, err error) {
+	start := time.Now()
+	defer func() {
+		c.queryComplete.observe(time.Since(start))
+		if err != nil {
+			c.queryFailures.Add(1)
+		}
+	}()
+
+	if c.inFlight != nil {
+		select {
+		case c.inFlight <- struct{}{}:
+			defer func() { <-c.inFlight }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
 
 	msgCh := c.Subscribe()
+	defer c.unsubscribe(msgCh)
 
-	if err := c.Query(msg); err != nil {
+	o := queryOptions(opts)
+	sent := time.Now()
+	if err := c.sendQuestion(ctx, o.first(question)); err != nil {
 		return nil, err
 	}
+	resend := newRetransmitter(o)
+	defer resend.stop()
 
 	for {
 		select {
@@ -157,15 +434,203 @@ func (c *client) QueryFirst(ctx context.Context, question dns.Question) (dns.RR,
 				return nil, errors.New("client closed")
 			}
 
+			if !carriesAnswers(resp.Msg) {
+				continue
+			}
 			for _, ans := range resp.Answer {
-				if ans.Header().Name == question.Name &&
-					ans.Header().Rrtype == question.Qtype &&
-					ans.Header().Class == question.Qclass {
-					return ans, nil
+				if answers(ans, question) {
+					c.firstAnswer.observe(time.Since(sent))
+					return resp, nil
 				}
 			}
+		case <-resend.C():
+			c.resendQuestion(ctx, question)
+			resend.next()
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		}
 	}
 }
+
+// QueryAll sends a query and delivers every matching answer on the returned
+// channel until ctx is done or the client is closed, when the channel is
+// closed. It returns at once; as a continuous query (RFC 6762 §5.2), the
+// first question goes out after a random 20-120ms delay and is retransmitted
+// as QueryOptions describes for as long as the query runs. Failed sends are
+// only logged, since the query carries on. Fresh cached answers come first.
+// Answers are delivered as received, so announcements repeat records and a
+// goodbye arrives as a record with a zero TTL. Stop listening by cancelling
+// ctx; a reader that falls behind without doing so makes answers drop as
+// with a slow Subscribe reader.
+func (c *client) QueryAll(ctx context.Context, question dns.Question, opts ...QueryOptions) (<-chan dns.RR, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	o := queryOptions(opts)
+	msgCh := c.Subscribe()
+
+	out := make(chan dns.RR, 32)
+	go func() {
+		defer close(out)
+		defer c.unsubscribe(msgCh)
+
+		// no retransmissions until the first question is out
+		resend := &retransmitter{}
+		defer func() { resend.stop() }()
+		var first <-chan time.Time
+		if c.passive {
+			resend = newRetransmitter(o)
+		} else {
+			t := time.NewTimer(firstQueryDelay + rand.N(firstQueryJitter))
+			defer t.Stop()
+			first = t.C
+		}
+
+		send := func(rr dns.RR) bool {
+			select {
+			case out <- rr:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, rr := range c.CachedLookup(question) {
+			if answers(rr, question) && !send(rr) {
+				return
+			}
+		}
+		for {
+			select {
+			case msg, ok := <-msgCh:
+				if !ok {
+					return
+				}
+				if !carriesAnswers(msg.Msg) {
+					continue
+				}
+				for _, ans := range msg.Answer {
+					if answers(ans, question) && !send(ans) {
+						return
+					}
+				}
+			case <-first:
+				first = nil
+				if err := c.sendContinuous(ctx, o.first(question)); err != nil && ctx.Err() == nil {
+					logger.Warn("failed to send query", slog.String("name", question.Name), slog.Any("error", err))
+				}
+				resend = newRetransmitter(o)
+			case <-resend.C():
+				if err := c.sendContinuous(ctx, question); err != nil && ctx.Err() == nil {
+					logger.Warn("failed to retransmit query", slog.String("name", question.Name), slog.Any("error", err))
+				}
+				resend.next()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// sendQuestion sends a query for q, merged with other questions if coalescing
+// is enabled.
+func (c *client) sendQuestion(ctx context.Context, q dns.Question) error {
+	if c.passive {
+		// just wait for someone else's query to draw the answer out
+		return nil
+	}
+	if c.coalesce == nil {
+		msg := new(dns.Msg)
+		msg.Question = []dns.Question{q}
+		return c.Query(msg)
+	}
+
+	select {
+	case err := <-c.coalesce.add(q):
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendContinuous sends a question of a continuous query with the cached
+// answers that are fresh enough in the answer section, so that responders
+// only answer with what the client is missing or about to lose (RFC 6762
+// §7.1). Known answers that would not fit in one packet are left out,
+// which just draws a few redundant answers. One-shot queries do not carry
+// known answers, as they wait for a whole response message.
+func (c *client) sendContinuous(ctx context.Context, q dns.Question) error {
+	if c.passive || c.cache == nil {
+		return c.sendQuestion(ctx, q)
+	}
+	known := c.cache.knownAnswers(q, time.Now())
+	if len(known) == 0 {
+		return c.sendQuestion(ctx, q)
+	}
+
+	msg := new(dns.Msg)
+	msg.Question = []dns.Question{q}
+	for _, rr := range known {
+		msg.Answer = append(msg.Answer, rr)
+		if msg.Len() > maxQueryLen {
+			msg.Answer = msg.Answer[:len(msg.Answer)-1]
+			break
+		}
+	}
+	return c.Query(msg)
+}
+
+// resendQuestion retransmits q. A failure is only logged: the query carries
+// on and the next retransmission may get through.
+func (c *client) resendQuestion(ctx context.Context, q dns.Question) {
+	if err := c.sendQuestion(ctx, q); err != nil && ctx.Err() == nil {
+		logger.Warn("failed to retransmit query", slog.String("name", q.Name), slog.Any("error", err))
+	}
+}
+
+// carriesAnswers reports whether m's Answer section holds answers rather than
+// a querier's known-answer list. Only responses qualify, including legacy
+// unicast responses to our ephemeral port that echo the question. A query
+// never does, even without questions: that is the continuation of a
+// known-answer list split over several packets (RFC 6762 §7.2).
+func carriesAnswers(m *dns.Msg) bool {
+	return m.Response
+}
+
+// answers reports whether rr answers q. Names compare case-insensitively and
+// the cache-flush bit (top bit of the class) is ignored. It runs for every
+// record of every received message while queries are pending, so the cheap
+// integer checks come first and nothing is allocated.
+func answers(rr dns.RR, q dns.Question) bool {
+	h := rr.Header()
+	return h.Rrtype == q.Qtype &&
+		h.Class&^cacheFlushBit == q.Qclass&^cacheFlushBit &&
+		equalNames(h.Name, q.Name)
+}
+
+// equalNames compares domain names the way DNS does: ASCII letters
+// case-insensitively and every other byte exactly (RFC 4343). Unlike
+// strings.EqualFold it applies no Unicode folding, so e.g. the Kelvin sign
+// does not match "k", and it rejects names of different length up front.
+func equalNames(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		ca, cb := a[i], b[i]
+		if ca == cb {
+			continue
+		}
+		if 'A' <= ca && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if 'A' <= cb && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
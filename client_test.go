@@ -0,0 +1,28 @@
+package simplemdns
+
+import "testing"
+
+func TestEqualNames(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"host.local.", "host.local.", true},
+		{"HOST.Local.", "host.local.", true},
+		{"host.local.", "host.local", false},
+		{"host.local.", "hosts.local.", false},
+		{"host-a.local.", "host-b.local.", false},
+		// only ASCII letters fold
+		{"@.local.", "`.local.", false},
+		{"[.local.", "{.local.", false},
+		{"café.local.", "CAFÉ.local.", false},
+		{"café.local.", "CAFé.local.", true},
+		{"K.local.", "k.local.", false}, // Kelvin sign
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		if got := equalNames(tt.a, tt.b); got != tt.want {
+			t.Errorf("equalNames(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
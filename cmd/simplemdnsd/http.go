@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/oosawy/simplemdns"
+)
+
+// maxRegisterBody bounds the JSON body of a register request.
+const maxRegisterBody = 64 << 10
+
+// httpHandler exposes the same operations as the control socket over HTTP:
+//
+//	GET /v1/resolve?name=printer.local.&type=A&timeout=2s
+//	    (400 for a bad request, 504 if nothing answered in time, 502 for
+//	    other failures; the timeout is capped at 30s)
+//	GET /v1/services?service=_http._tcp&timeout=2s
+//	    (the instances found within the timeout; 400 for a bad request)
+//	GET /v1/watch   (streams line-delimited JSON records)
+//	POST /v1/register   (body as the socket's register request, without op;
+//	    answers {"name":"..."} at once and keeps the service published until
+//	    the request is closed; 400 for a bad request, 409 if the name stayed
+//	    in use, 502 for other failures)
+func (s *server) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/resolve", func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+
+		q, timeout, err := resolveQuestion(request{
+			Op:      "resolve",
+			Name:    params.Get("name"),
+			Type:    params.Get("type"),
+			Timeout: params.Get("timeout"),
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(response{Error: err.Error()})
+			return
+		}
+
+		rr, err := s.lookup(q, timeout)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			w.WriteHeader(http.StatusGatewayTimeout)
+		case err != nil:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		if err != nil {
+			json.NewEncoder(w).Encode(response{Error: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(response{Record: rr.String()})
+	})
+
+	mux.HandleFunc("GET /v1/services", func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+
+		q, timeout, err := listQuestion(request{
+			Op:      "list",
+			Service: params.Get("service"),
+			Timeout: params.Get("timeout"),
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(response{Error: err.Error()})
+			return
+		}
+
+		instances, err := s.list(q, timeout)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(response{Error: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(response{Instances: instances})
+	})
+
+	mux.HandleFunc("POST /v1/register", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var req request
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRegisterBody)).Decode(&req)
+		if err != nil {
+			err = fmt.Errorf("invalid request: %w", err)
+		} else {
+			err = checkRegister(req)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(response{Error: err.Error()})
+			return
+		}
+		// with the body read to its end, the request context ends when the
+		// client goes away
+		io.Copy(io.Discard, r.Body)
+
+		svc, err := s.register(req)
+		switch {
+		case errors.Is(err, simplemdns.ErrNameConflict):
+			w.WriteHeader(http.StatusConflict)
+		case err != nil:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		if err != nil {
+			json.NewEncoder(w).Encode(response{Error: err.Error()})
+			return
+		}
+		defer func() {
+			if err := svc.Unregister(); err != nil {
+				slog.Warn("failed to unregister service", "name", svc.Name(), "error", err)
+			}
+		}()
+
+		// The service lives as long as the request, as it would as long as a
+		// socket connection.
+		json.NewEncoder(flushWriter{w}).Encode(response{Name: svc.Name()})
+		<-r.Context().Done()
+	})
+
+	mux.HandleFunc("GET /v1/watch", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		s.watch(json.NewEncoder(flushWriter{w}), r.Context().Done())
+	})
+
+	return mux
+}
+
+// ServeHTTP serves the HTTP API on addr until Close is called.
+func (s *server) ServeHTTP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	hs := &http.Server{Handler: s.httpHandler(), BaseContext: func(net.Listener) context.Context { return s.ctx }}
+	s.wg.Go(func() {
+		if err := hs.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server failed", "error", err)
+		}
+	})
+	s.wg.Go(func() {
+		<-s.ctx.Done()
+		// Handlers end with s.ctx; waiting for them lets registered services
+		// be unregistered before Serve closes the responder.
+		hs.Shutdown(context.Background())
+	})
+	return nil
+}
+
+// flushWriter flushes after every write so streamed records reach the client
+// immediately.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
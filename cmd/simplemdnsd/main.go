@@ -0,0 +1,92 @@
+// Command simplemdnsd is a long-running process that owns one simplemdns
+// client and responder and serves resolve, list, watch and register requests
+// over a Unix socket, so short-lived tools don't each join the multicast
+// groups themselves.
+//
+// The protocol is line-delimited JSON. Each request is one object:
+//
+//	{"op":"resolve","name":"printer.local.","type":"A","timeout":"2s"}
+//	{"op":"list","service":"_http._tcp","timeout":"2s"}
+//	{"op":"watch"}
+//	{"op":"register","instance":"My Web","service":"_http._tcp","port":8080,"txt":["path=/"]}
+//
+// resolve answers with a single {"record":"..."} or {"error":"..."} line;
+// list with {"instances":[...]}, the service instances that answered within
+// the timeout; watch streams one {"record":"..."} line per received record until the
+// connection is closed. register answers with {"name":"..."}, the full
+// service name in use after any rename, and keeps the service published until
+// the connection is closed.
+//
+// The control socket is only accessible to the user running the daemon. It
+// lives in $XDG_RUNTIME_DIR, or /run when that is not set.
+//
+// With -http, the same operations are also served over HTTP as
+// GET /v1/resolve?name=...&type=...&timeout=..., GET /v1/services?service=...,
+// GET /v1/watch and POST /v1/register, for programs that cannot speak to a
+// Unix socket. A service registered over HTTP stays published until its
+// request is closed.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/oosawy/simplemdns"
+)
+
+func main() {
+	socketPath := flag.String("socket", defaultSocketPath(), "path of the control socket")
+	httpAddr := flag.String("http", "", "also serve the HTTP API on this address (e.g. localhost:8053)")
+	verbose := flag.Bool("v", false, "enable debug logging")
+	flag.Parse()
+
+	if *verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	client, err := simplemdns.NewClient()
+	if err != nil {
+		slog.Error("failed to create mDNS client", "error", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	newResponder := func() (mdnsResponder, error) { return simplemdns.NewResponder() }
+	srv, err := newServer(client, newResponder, *socketPath)
+	if err != nil {
+		slog.Error("failed to listen on control socket", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("listening", "socket", *socketPath)
+
+	if *httpAddr != "" {
+		if err := srv.ServeHTTP(*httpAddr); err != nil {
+			slog.Error("failed to listen for HTTP", "error", err)
+			srv.Close()
+			os.Exit(1)
+		}
+		slog.Info("serving HTTP API", "addr", *httpAddr)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		srv.Close()
+	}()
+
+	srv.Serve()
+}
+
+// defaultSocketPath returns the control socket path in the user's runtime
+// directory, which unlike /tmp is not shared with other users.
+func defaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "simplemdnsd.sock")
+	}
+	return "/run/simplemdnsd.sock"
+}
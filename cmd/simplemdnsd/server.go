@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/oosawy/simplemdns"
+)
+
+const (
+	defaultResolveTimeout = 3 * time.Second
+	maxResolveTimeout     = 30 * time.Second // longer timeouts asked for are cut to this
+)
+
+// mdnsClient is the part of the simplemdns client the server uses.
+type mdnsClient interface {
+	QueryFirst(ctx context.Context, question dns.Question, opts ...simplemdns.QueryOptions) (dns.RR, error)
+	QueryAll(ctx context.Context, question dns.Question, opts ...simplemdns.QueryOptions) (<-chan dns.RR, error)
+	Subscribe() <-chan *simplemdns.ReceivedMsg
+}
+
+// mdnsResponder is the part of the simplemdns responder the server uses.
+type mdnsResponder interface {
+	RegisterService(instance, serviceType string, port int, txt []string) (*simplemdns.Service, error)
+	Close() error
+}
+
+type request struct {
+	Op      string `json:"op"`
+	Name    string `json:"name,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+
+	// register, and list with Service
+	Instance string   `json:"instance,omitempty"`
+	Service  string   `json:"service,omitempty"`
+	Port     int      `json:"port,omitempty"`
+	TXT      []string `json:"txt,omitempty"`
+}
+
+type response struct {
+	Record    string   `json:"record,omitempty"`
+	Name      string   `json:"name,omitempty"` // registered service name, whose instance may differ from the one asked for
+	Instances []string `json:"instances,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+type server struct {
+	client mdnsClient
+	ln     net.Listener
+
+	// The responder binds the mDNS port, which a system daemon may hold, so
+	// it is only created once something is registered.
+	newResponder func() (mdnsResponder, error)
+	respMu       sync.Mutex
+	responder    mdnsResponder
+
+	// One client subscription is fanned out to all watching connections.
+	mu       sync.Mutex
+	watchers map[chan dns.RR]struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newServer(client mdnsClient, newResponder func() (mdnsResponder, error), path string) (*server, error) {
+	ln, err := net.Listen("unix", path)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		// Remove a socket left behind by a crashed daemon, but never steal
+		// one that is still being served.
+		if c, derr := net.Dial("unix", path); derr == nil {
+			c.Close()
+			return nil, err
+		}
+		os.Remove(path)
+		ln, err = net.Listen("unix", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Whoever can connect can register services in our name.
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &server{
+		client:       client,
+		ln:           ln,
+		newResponder: newResponder,
+		watchers:     make(map[chan dns.RR]struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	s.wg.Go(s.fanOut)
+	return s, nil
+}
+
+// Serve accepts connections until Close is called.
+func (s *server) Serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("accept failed", "error", err)
+			}
+			break
+		}
+		s.wg.Go(func() { s.serveConn(conn) })
+	}
+	s.wg.Wait()
+
+	s.respMu.Lock()
+	defer s.respMu.Unlock()
+	if s.responder != nil {
+		s.responder.Close()
+	}
+}
+
+func (s *server) Close() error {
+	s.cancel()
+	return s.ln.Close()
+}
+
+func (s *server) fanOut() {
+	msgs := s.client.Subscribe()
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+			s.mu.Lock()
+			for w := range s.watchers {
+				for _, rr := range msgRecords(msg.Msg) {
+					select {
+					case w <- rr:
+					default:
+						// slow watcher; drop rather than stall everyone
+					}
+				}
+			}
+			s.mu.Unlock()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	// Unblock reads when the server shuts down.
+	stop := context.AfterFunc(s.ctx, func() { conn.Close() })
+	defer stop()
+
+	// Services registered over the connection live as long as it does.
+	var services []*simplemdns.Service
+	defer func() {
+		for _, svc := range services {
+			if err := svc.Unregister(); err != nil {
+				slog.Warn("failed to unregister service", "name", svc.Name(), "error", err)
+			}
+		}
+	}()
+
+	enc := json.NewEncoder(conn)
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		var req request
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			enc.Encode(response{Error: "invalid request: " + err.Error()})
+			continue
+		}
+
+		switch req.Op {
+		case "resolve":
+			enc.Encode(s.resolve(req))
+		case "list":
+			enc.Encode(s.listResponse(req))
+		case "register":
+			if err := checkRegister(req); err != nil {
+				enc.Encode(response{Error: err.Error()})
+				continue
+			}
+			svc, err := s.register(req)
+			if err != nil {
+				enc.Encode(response{Error: err.Error()})
+				continue
+			}
+			services = append(services, svc)
+			enc.Encode(response{Name: svc.Name()})
+		case "watch":
+			// Keep reading so a closed connection is noticed even when no
+			// records arrive.
+			gone := make(chan struct{})
+			go func() {
+				for sc.Scan() {
+				}
+				close(gone)
+			}()
+			s.watch(enc, gone)
+			return
+		default:
+			enc.Encode(response{Error: fmt.Sprintf("unknown op %q", req.Op)})
+		}
+	}
+}
+
+func (s *server) resolve(req request) response {
+	q, timeout, err := resolveQuestion(req)
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+	rr, err := s.lookup(q, timeout)
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+	return response{Record: rr.String()}
+}
+
+// resolveQuestion returns the question and timeout of a resolve request. Its
+// errors are the caller's mistakes, as opposed to failed lookups.
+func resolveQuestion(req request) (dns.Question, time.Duration, error) {
+	if req.Name == "" {
+		return dns.Question{}, 0, errors.New("missing name")
+	}
+	qtype := dns.TypeA
+	if req.Type != "" {
+		t, ok := dns.StringToType[req.Type]
+		if !ok {
+			return dns.Question{}, 0, fmt.Errorf("unknown type %q", req.Type)
+		}
+		qtype = t
+	}
+
+	timeout, err := parseTimeout(req.Timeout)
+	if err != nil {
+		return dns.Question{}, 0, err
+	}
+	return dns.Question{Name: dns.Fqdn(req.Name), Qtype: qtype, Qclass: dns.ClassINET}, timeout, nil
+}
+
+// parseTimeout returns the timeout asked for, the default if none was, cut
+// to maxResolveTimeout.
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultResolveTimeout, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout: %w", err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid timeout: %s is not positive", s)
+	}
+	return min(d, maxResolveTimeout), nil
+}
+
+// listQuestion returns the PTR question and timeout of a list request, whose
+// service is a type like "_http._tcp".
+func listQuestion(req request) (dns.Question, time.Duration, error) {
+	name, err := serviceName(req.Service)
+	if err != nil {
+		return dns.Question{}, 0, err
+	}
+	timeout, err := parseTimeout(req.Timeout)
+	if err != nil {
+		return dns.Question{}, 0, err
+	}
+	return dns.Question{Name: name, Qtype: dns.TypePTR, Qclass: dns.ClassINET}, timeout, nil
+}
+
+// serviceName returns the name in .local of service, a type like
+// "_http._tcp", optionally followed by ".local.".
+func serviceName(service string) (string, error) {
+	if service == "" {
+		return "", errors.New("missing service")
+	}
+	name := dns.Fqdn(service)
+	if !strings.HasSuffix(strings.ToLower(name), ".local.") {
+		name += "local."
+	}
+	labels := dns.SplitDomainName(name)
+	if len(labels) != 3 || !strings.HasPrefix(labels[0], "_") ||
+		!strings.EqualFold(labels[1], "_tcp") && !strings.EqualFold(labels[1], "_udp") {
+		return "", fmt.Errorf("invalid service %q; want e.g. \"_http._tcp\"", service)
+	}
+	return name, nil
+}
+
+func (s *server) listResponse(req request) response {
+	q, timeout, err := listQuestion(req)
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+	instances, err := s.list(q, timeout)
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+	return response{Instances: instances}
+}
+
+// list returns the service instances answering q within timeout, sorted,
+// leaving out those said goodbye to meanwhile.
+func (s *server) list(q dns.Question, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+	rrs, err := s.client.QueryAll(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make(map[string]struct{})
+	for rr := range rrs {
+		ptr, ok := rr.(*dns.PTR)
+		if !ok {
+			continue
+		}
+		if ptr.Hdr.Ttl == 0 {
+			delete(instances, ptr.Ptr)
+		} else {
+			instances[ptr.Ptr] = struct{}{}
+		}
+	}
+	return slices.Sorted(maps.Keys(instances)), nil
+}
+
+func (s *server) lookup(q dns.Question, timeout time.Duration) (dns.RR, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+	return s.client.QueryFirst(ctx, q)
+}
+
+// checkRegister returns what is missing from a register request. The
+// responder checks the rest.
+func checkRegister(req request) error {
+	if req.Instance == "" {
+		return errors.New("missing instance")
+	}
+	if _, err := serviceName(req.Service); err != nil {
+		return err
+	}
+	if req.Port <= 0 || req.Port > 0xffff {
+		return fmt.Errorf("invalid port %d", req.Port)
+	}
+	if len(req.TXT) > 0 {
+		return simplemdns.ValidateTXT(req.TXT)
+	}
+	return nil
+}
+
+// register publishes the service described by req, creating the responder
+// on first use.
+func (s *server) register(req request) (*simplemdns.Service, error) {
+	s.respMu.Lock()
+	if s.responder == nil {
+		r, err := s.newResponder()
+		if err != nil {
+			s.respMu.Unlock()
+			return nil, fmt.Errorf("cannot start responder: %w", err)
+		}
+		s.responder = r
+	}
+	r := s.responder
+	s.respMu.Unlock()
+
+	return r.RegisterService(req.Instance, req.Service, req.Port, req.TXT)
+}
+
+// watch streams records to enc until the connection goes away or the server
+// stops.
+func (s *server) watch(enc *json.Encoder, gone <-chan struct{}) {
+	w := make(chan dns.RR, 64)
+	s.mu.Lock()
+	s.watchers[w] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, w)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case rr := <-w:
+			if err := enc.Encode(response{Record: rr.String()}); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func msgRecords(m *dns.Msg) []dns.RR {
+	rrs := make([]dns.RR, 0, len(m.Answer)+len(m.Ns)+len(m.Extra))
+	rrs = append(rrs, m.Answer...)
+	rrs = append(rrs, m.Ns...)
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
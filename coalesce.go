@@ -0,0 +1,83 @@
+package simplemdns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// coalescer merges questions submitted within a short window into as few
+// query packets as possible.
+type coalescer struct {
+	window time.Duration
+	send   func(*dns.Msg) error
+
+	mu      sync.Mutex
+	pending []dns.Question
+	waiters []chan error
+}
+
+func newCoalescer(window time.Duration, send func(*dns.Msg) error) *coalescer {
+	return &coalescer{window: window, send: send}
+}
+
+// add queues q for the next packet and returns a channel that receives the
+// send result once the window closes.
+func (co *coalescer) add(q dns.Question) <-chan error {
+	done := make(chan error, 1)
+
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if len(co.pending) == 0 {
+		time.AfterFunc(co.window, co.flush)
+	}
+	co.pending = append(co.pending, q)
+	co.waiters = append(co.waiters, done)
+	return done
+}
+
+func (co *coalescer) flush() {
+	co.mu.Lock()
+	questions, waiters := co.pending, co.waiters
+	co.pending, co.waiters = nil, nil
+	co.mu.Unlock()
+
+	var err error
+	for _, msg := range packQuestions(questions) {
+		if serr := co.send(msg); serr != nil {
+			err = serr
+		}
+	}
+	for _, w := range waiters {
+		w <- err
+	}
+}
+
+// packQuestions dedupes questions, whose names compare case-insensitively,
+// and splits them into messages no larger than maxQueryLen.
+func packQuestions(questions []dns.Question) []*dns.Msg {
+	var msgs []*dns.Msg
+	cur := new(dns.Msg)
+	seen := make(map[dns.Question]struct{}, len(questions))
+	for _, q := range questions {
+		key := dns.Question{Name: strings.ToLower(q.Name), Qtype: q.Qtype, Qclass: q.Qclass}
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		cur.Question = append(cur.Question, q)
+		if cur.Len() > maxQueryLen && len(cur.Question) > 1 {
+			cur.Question = cur.Question[:len(cur.Question)-1]
+			msgs = append(msgs, cur)
+			cur = new(dns.Msg)
+			cur.Question = []dns.Question{q}
+		}
+	}
+	if len(cur.Question) > 0 {
+		msgs = append(msgs, cur)
+	}
+	return msgs
+}
@@ -0,0 +1,57 @@
+package simplemdns
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPackQuestions(t *testing.T) {
+	q := func(name string, qtype uint16) dns.Question {
+		return dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET}
+	}
+	// 4 labels of 60 bytes make a 250-byte name, so five questions fit a
+	// packet
+	long := func(i int) dns.Question {
+		label := fmt.Sprintf("%060d", i)
+		return q(strings.Repeat(label+".", 4), dns.TypeA)
+	}
+	var longs []dns.Question
+	for i := range 12 {
+		longs = append(longs, long(i))
+	}
+
+	tests := []struct {
+		name      string
+		questions []dns.Question
+		want      [][]dns.Question
+	}{
+		{"none", nil, nil},
+		{"one", []dns.Question{q("a.local.", dns.TypeA)}, [][]dns.Question{{q("a.local.", dns.TypeA)}}},
+		{"duplicates", []dns.Question{q("a.local.", dns.TypeA), q("b.local.", dns.TypeA), q("a.local.", dns.TypeA)},
+			[][]dns.Question{{q("a.local.", dns.TypeA), q("b.local.", dns.TypeA)}}},
+		{"duplicates differing in case", []dns.Question{q("a.local.", dns.TypeA), q("A.Local.", dns.TypeA)},
+			[][]dns.Question{{q("a.local.", dns.TypeA)}}},
+		{"other types kept", []dns.Question{q("a.local.", dns.TypeA), q("a.local.", dns.TypeAAAA)},
+			[][]dns.Question{{q("a.local.", dns.TypeA), q("a.local.", dns.TypeAAAA)}}},
+		{"split", longs, [][]dns.Question{longs[:5], longs[5:10], longs[10:]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := packQuestions(tt.questions)
+			if len(msgs) != len(tt.want) {
+				t.Fatalf("got %d messages, want %d", len(msgs), len(tt.want))
+			}
+			for i, m := range msgs {
+				if m.Len() > maxQueryLen {
+					t.Errorf("message %d is %d bytes, over %d", i, m.Len(), maxQueryLen)
+				}
+				if fmt.Sprint(m.Question) != fmt.Sprint(tt.want[i]) {
+					t.Errorf("message %d: got %v, want %v", i, m.Question, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -4,6 +4,14 @@ import (
 	"github.com/oosawy/simplemdns/internal/transport"
 )
 
+// cacheFlushBit is the top bit of the rrclass in mDNS resource records
+// (RFC 6762 §10.2). In questions, the same bit requests a unicast response.
+const cacheFlushBit = 1 << 15
+
+// maxQueryLen keeps a query with known answers within a typical Ethernet
+// MTU after the IPv6 and UDP headers (RFC 6762 §17).
+const maxQueryLen = 1500 - 40 - 8
+
 const (
 	// IPv4 specifies to use only IPv4 for mDNS communication.
 	IPv4 = transport.IPv4
@@ -20,4 +28,32 @@ const (
 	BindMDNSPort = transport.BindMDNSPort // i.e. 0.0.0.0:5353
 	// BindMDNSGaddr binds to the mDNS multicast group address.
 	BindMDNSGaddr = transport.BindMDNSGaddr // i.e. 224.0.0.251:5353
+	// BindAuto binds the mDNS port if possible (full responder capability)
+	// and falls back to the zero address (query-only) otherwise. The outcome
+	// is reported by Status.
+	BindAuto = transport.BindAuto
+)
+
+const (
+	// Lenient tolerates common RFC 6762 deviations from real-world devices,
+	// such as responses sent from ports other than 5353. It is the default.
+	Lenient = transport.Lenient
+	// Strict enforces RFC 6762 rules on received traffic and drops violations.
+	Strict = transport.Strict
 )
+
+// StallError is reported on Errors when a receive loop stops delivering
+// packets after we sent a multicast message.
+type StallError = transport.StallError
+
+// PortInUseError is returned by NewClient when the mDNS port is owned by
+// another process, such as avahi-daemon or mDNSResponder.
+type PortInUseError = transport.PortInUseError
+
+// ReceivedMsg is a message delivered by Subscribe, with its sender, the
+// receiving interface and the destination address.
+type ReceivedMsg = transport.ReceivedMsg
+
+// FamilyError, listed in Status.Failed, reports why the socket for one IP
+// family could not be set up while the other works.
+type FamilyError = transport.FamilyError
@@ -0,0 +1,207 @@
+package simplemdns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/oosawy/simplemdns/internal/transport"
+)
+
+// ErrNotChecked marks a check in EnvReport that could not be run.
+var ErrNotChecked = errors.New("not checked")
+
+// ContainerError reports that the process runs in a container network that
+// multicast cannot leave. See DetectContainer.
+type ContainerError = transport.ContainerError
+
+// DetectContainer returns a *ContainerError explaining what to do if the
+// process seems to run in a container (Docker, Kubernetes, Podman...) without
+// host networking, and nil otherwise. NewClient logs the same finding as a
+// warning but carries on, since peers on the same container network are
+// still reachable.
+func DetectContainer() *ContainerError {
+	return transport.DetectContainer()
+}
+
+// PortOwner is a process that appears to hold the mDNS port.
+type PortOwner = transport.PortOwner
+
+// EnvReport is the result of CheckEnvironment.
+type EnvReport struct {
+	Interfaces []InterfaceCheck
+
+	// Loopback4 and Loopback6 are nil if a multicast query we sent was received
+	// back on the mDNS port, which proves sending, group membership and local
+	// delivery all work. ErrNotChecked means the family was unavailable or the
+	// port was taken by another process.
+	Loopback4 error
+	Loopback6 error
+
+	// PortOwners lists processes holding UDP 5353, usually a system mDNS
+	// daemon such as avahi-daemon or mDNSResponder, if binding it failed.
+	// Outside Linux the owner is a guess from the platform.
+	PortOwners []PortOwner
+
+	// Container is set if the process seems to run in a container without
+	// host networking, where multicast does not reach the LAN.
+	Container *ContainerError
+
+	// Hints are human-readable suggestions derived from the checks above.
+	Hints []string
+}
+
+// InterfaceCheck is the per-interface part of EnvReport.
+type InterfaceCheck struct {
+	Interface  net.Interface
+	IPv4, IPv6 bool  // whether the interface has addresses of the family
+	Join4      error // result of joining 224.0.0.251; ErrNotChecked without IPv4
+	Join6      error // result of joining ff02::fb; ErrNotChecked without IPv6
+}
+
+// OK reports whether mDNS looks usable: some interface joined a group and a
+// loopback check succeeded.
+func (r *EnvReport) OK() bool {
+	joined := false
+	for _, ic := range r.Interfaces {
+		if ic.Join4 == nil || ic.Join6 == nil {
+			joined = true
+		}
+	}
+	return joined && (r.Loopback4 == nil || r.Loopback6 == nil)
+}
+
+// CheckEnvironment runs a battery of checks for common mDNS problems: no
+// usable multicast interfaces, failing group joins, multicast not looping
+// back (often a firewall), a system daemon owning the port, and a container
+// network that multicast cannot leave. It sends a
+// query for a random name, so it generates a little traffic.
+func CheckEnvironment(ctx context.Context) (*EnvReport, error) {
+	ifaces, err := transport.MulticastInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &EnvReport{
+		Loopback4: ErrNotChecked,
+		Loopback6: ErrNotChecked,
+	}
+
+	var has4, has6 bool
+	for _, iface := range ifaces {
+		ic := InterfaceCheck{Interface: iface, Join4: ErrNotChecked, Join6: ErrNotChecked}
+		if ic.IPv4 = transport.InterfaceSupports(&iface, IPv4); ic.IPv4 {
+			ic.Join4 = transport.CheckJoin(&iface, IPv4)
+			has4 = has4 || ic.Join4 == nil
+		}
+		if ic.IPv6 = transport.InterfaceSupports(&iface, IPv6); ic.IPv6 {
+			ic.Join6 = transport.CheckJoin(&iface, IPv6)
+			has6 = has6 || ic.Join6 == nil
+		}
+		r.Interfaces = append(r.Interfaces, ic)
+	}
+
+	r.Container = transport.DetectContainer()
+
+	// Binding the port tells whether another process holds it; the owners
+	// found by looking (a guess outside Linux) are only reported then.
+	for _, lb := range []struct {
+		family transport.IPVersion
+		ok     bool
+		err    *error
+	}{{IPv4, has4, &r.Loopback4}, {IPv6, has6, &r.Loopback6}} {
+		if !lb.ok {
+			continue
+		}
+		err := checkLoopback(ctx, lb.family)
+		var inUse *PortInUseError
+		if errors.As(err, &inUse) {
+			// leave ErrNotChecked; the port hint covers it
+			if len(r.PortOwners) == 0 {
+				r.PortOwners = inUse.Owners
+			}
+			if len(r.PortOwners) == 0 {
+				r.PortOwners = []PortOwner{{Name: "another process"}}
+			}
+			continue
+		}
+		*lb.err = err
+	}
+
+	r.Hints = r.hints(has4, has6)
+	return r, nil
+}
+
+func (r *EnvReport) hints(has4, has6 bool) []string {
+	var hints []string
+	if len(r.Interfaces) == 0 {
+		hints = append(hints, "no interface is up and multicast-capable; check that the network is connected")
+	} else if !has4 && !has6 {
+		hints = append(hints, "joining the mDNS multicast group failed on every interface")
+	}
+	if r.Container != nil {
+		hints = append(hints, fmt.Sprintf("running in a %s container without host networking; mDNS traffic stays inside the container network unless it uses host networking or the host runs an mDNS reflector", r.Container.Runtime))
+	}
+	if len(r.PortOwners) > 0 {
+		hints = append(hints, fmt.Sprintf("UDP 5353 is held by %s; use BindZeroAddr for queries, SharePort to bind the port alongside it if it allows reuse, or Broker to share the port among simplemdns processes", r.PortOwners[0].Name))
+	}
+	for _, lb := range []struct {
+		family string
+		err    error
+	}{{"IPv4", r.Loopback4}, {"IPv6", r.Loopback6}} {
+		if lb.err == nil || errors.Is(lb.err, ErrNotChecked) {
+			continue
+		}
+		hint := fmt.Sprintf("%s multicast did not loop back (%v); a firewall may be dropping UDP 5353", lb.family, lb.err)
+		if runtime.GOOS == "windows" {
+			hint += "; allow inbound UDP 5353 in Windows Defender Firewall"
+		}
+		hints = append(hints, hint)
+	}
+	return hints
+}
+
+// checkLoopback queries a random name on the mDNS port and waits for our own
+// packet to come back.
+func checkLoopback(ctx context.Context, v transport.IPVersion) error {
+	c, err := NewClient(ClientOptions{IPVersion: v, BindTo: BindMDNSPort, StallTimeout: -1, StaticIfaces: true, CacheSize: -1})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var nonce [6]byte
+	rand.Read(nonce[:])
+	name := "simplemdns-check-" + hex.EncodeToString(nonce[:]) + ".local."
+
+	msgs := c.Subscribe()
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, dns.TypeTXT)
+	msg.Id = 0
+	if err := c.Query(msg); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	for {
+		select {
+		case m, ok := <-msgs:
+			if !ok {
+				return errors.New("client closed")
+			}
+			if len(m.Question) > 0 && m.Question[0].Name == name {
+				return nil
+			}
+		case <-ctx.Done():
+			return errors.New("own query not received within 1s")
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package simplemdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// connAttemptDelay is the Connection Attempt Delay of RFC 8305 §5: how long
+// to wait for one attempt before starting the next in parallel.
+const connAttemptDelay = 250 * time.Millisecond
+
+// DialAddrs connects to port on one of addrs, typically the A and AAAA records
+// of a resolved service, racing the attempts as RFC 8305 (Happy Eyeballs)
+// describes: addresses are tried alternating between IPv6 and IPv4, IPv6
+// first, each attempt starting when the previous one fails or after 250ms,
+// whichever comes first. The first connection established is returned and the
+// others are closed. Link-local IPv6 addresses need their Zone set.
+//
+// network is "tcp", "tcp4" or "tcp6", or "udp", "udp4" or "udp6"; those
+// ending in 4 or 6 restrict addrs to that family. Dialing UDP sends nothing,
+// so there is no race to run: the first address in the order above is used.
+func DialAddrs(ctx context.Context, network string, addrs []net.IPAddr, port int) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+	addrs = interleaveFamilies(network, addrs)
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses to dial")
+	}
+	if strings.HasPrefix(network, "udp") {
+		var d net.Dialer
+		return d.DialContext(ctx, network, net.JoinHostPort(addrs[0].String(), strconv.Itoa(port)))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	var d net.Dialer
+	var next, pending int
+	startNext := func() {
+		a := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(a.String(), strconv.Itoa(port)))
+			results <- result{conn, err}
+		}()
+	}
+	// closeLate closes connections from attempts that complete after we are
+	// done.
+	closeLate := func(n int) {
+		for ; n > 0; n-- {
+			if r := <-results; r.conn != nil {
+				r.conn.Close()
+			}
+		}
+	}
+
+	timer := time.NewTimer(connAttemptDelay)
+	defer timer.Stop()
+	startNext()
+
+	var errs []error
+	for {
+		select {
+		case <-timer.C:
+			if next < len(addrs) {
+				startNext()
+				timer.Reset(connAttemptDelay)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				go closeLate(pending)
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+			if next < len(addrs) {
+				startNext()
+				timer.Reset(connAttemptDelay)
+			} else if pending == 0 {
+				return nil, errors.Join(errs...)
+			}
+		case <-ctx.Done():
+			go closeLate(pending)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// resolutionDelay is how long to wait for the AAAA answer once the A answer is
+// in, and vice versa (RFC 8305 §3 recommends 50ms).
+const resolutionDelay = 50 * time.Millisecond
+
+// DialService resolves the service instance of serviceType (e.g. "My
+// Printer", "_ipp._tcp") and connects to it: it queries the SRV record,
+// selects a target by priority and weight (RFC 2782), resolves the target's
+// addresses and dials them with DialAddrs. The network is taken from the
+// protocol label of serviceType, "_tcp" or "_udp". serviceType may include the
+// domain; "local." is assumed otherwise.
+//
+// ctx bounds the whole operation and should carry a deadline, since mDNS
+// queries for names nobody answers only end when ctx does.
+func (c *client) DialService(ctx context.Context, instance, serviceType string) (net.Conn, error) {
+	serviceType = dns.Fqdn(serviceType)
+	if !strings.HasSuffix(strings.ToLower(serviceType), ".local.") {
+		serviceType += "local."
+	}
+	var network string
+	switch labels := dns.SplitDomainName(serviceType); {
+	case len(labels) >= 2 && equalNames(labels[1], "_tcp"):
+		network = "tcp"
+	case len(labels) >= 2 && equalNames(labels[1], "_udp"):
+		network = "udp"
+	default:
+		return nil, fmt.Errorf("service type %q has no _tcp or _udp protocol label", serviceType)
+	}
+	name := escapeInstance(instance) + "." + serviceType
+
+	resp, err := c.QueryMsg(ctx, dns.Question{Name: name, Qtype: dns.TypeSRV, Qclass: dns.ClassINET})
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", name, err)
+	}
+	srv := selectSRV(resp.Answer, name)
+	if srv == nil {
+		return nil, fmt.Errorf("resolve %s: no SRV record", name) // unreachable: QueryMsg returned an answer
+	}
+
+	addrs := addrsFromMsg(resp, srv.Target)
+	if len(addrs) == 0 {
+		addrs, err = c.lookupTarget(ctx, srv.Target)
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s: %w", srv.Target, err)
+		}
+	}
+	return DialAddrs(ctx, network, addrs, int(srv.Port))
+}
+
+// escapeInstance escapes the characters of a service instance name that are
+// special in presentation format, so it stays a single label (RFC 6763 §4.3).
+func escapeInstance(instance string) string {
+	r := strings.NewReplacer(`\`, `\\`, `.`, `\.`)
+	return r.Replace(instance)
+}
+
+// selectSRV picks the SRV record for name to use, following RFC 2782: the
+// lowest priority wins, and among equals a record is chosen at random in
+// proportion to its weight.
+func selectSRV(rrs []dns.RR, name string) *dns.SRV {
+	var best []*dns.SRV
+	for _, rr := range rrs {
+		srv, ok := rr.(*dns.SRV)
+		if !ok || !equalNames(srv.Hdr.Name, name) {
+			continue
+		}
+		switch {
+		case len(best) == 0 || srv.Priority < best[0].Priority:
+			best = []*dns.SRV{srv}
+		case srv.Priority == best[0].Priority:
+			best = append(best, srv)
+		}
+	}
+	if len(best) == 0 {
+		return nil
+	}
+
+	var total int
+	for _, srv := range best {
+		total += int(srv.Weight)
+	}
+	if total == 0 {
+		return best[rand.IntN(len(best))]
+	}
+	n := rand.IntN(total)
+	for _, srv := range best {
+		if n -= int(srv.Weight); n < 0 {
+			return srv
+		}
+	}
+	return best[len(best)-1]
+}
+
+// addrsFromMsg returns the addresses of host found anywhere in m; responders
+// usually put them in the additional section of an SRV answer (RFC 6763 §12.2).
+// Link-local IPv6 addresses get the zone of the interface m came in on, which
+// is where the host can be reached.
+func addrsFromMsg(m *ReceivedMsg, host string) []net.IPAddr {
+	zone := receivedZone(m)
+	var addrs []net.IPAddr
+	for _, section := range [][]dns.RR{m.Answer, m.Extra} {
+		for _, rr := range section {
+			if !equalNames(rr.Header().Name, host) {
+				continue
+			}
+			switch rr := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, net.IPAddr{IP: rr.A})
+			case *dns.AAAA:
+				a := net.IPAddr{IP: rr.AAAA}
+				if rr.AAAA.IsLinkLocalUnicast() {
+					a.Zone = zone
+				}
+				addrs = append(addrs, a)
+			}
+		}
+	}
+	return addrs
+}
+
+// receivedZone returns the name of the interface m came in on, for use as the
+// zone of link-local addresses, or the zone of its link-local sender when the
+// interface is unknown, as with messages relayed by a broker.
+func receivedZone(m *ReceivedMsg) string {
+	if m.IfIndex > 0 {
+		if iface, err := net.InterfaceByIndex(m.IfIndex); err == nil {
+			return iface.Name
+		}
+		return strconv.Itoa(m.IfIndex)
+	}
+	if m.Src != nil {
+		return m.Src.Zone
+	}
+	return ""
+}
+
+// lookupTarget resolves host, an SRV target, to its addresses. Names under
+// "local." are queried over mDNS for A and AAAA at once; after the first
+// family answers, the other gets resolutionDelay more to catch up. Other
+// names go to the system resolver.
+func (c *client) lookupTarget(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if !strings.HasSuffix(strings.ToLower(host), ".local.") {
+		return net.DefaultResolver.LookupIPAddr(ctx, host)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		addrs []net.IPAddr
+		err   error
+	}
+	results := make(chan result, 2)
+	for _, qtype := range []uint16{dns.TypeAAAA, dns.TypeA} {
+		go func() {
+			resp, err := c.QueryMsg(ctx, dns.Question{Name: host, Qtype: qtype, Qclass: dns.ClassINET})
+			if err != nil {
+				results <- result{err: err}
+				return
+			}
+			results <- result{addrs: addrsFromMsg(resp, host)}
+		}()
+	}
+
+	var addrs []net.IPAddr
+	var errs []error
+	var delay <-chan time.Time
+	for range 2 {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				errs = append(errs, r.err)
+				continue
+			}
+			// the other family's records often come along as additionals
+			for _, a := range r.addrs {
+				if !slices.ContainsFunc(addrs, func(b net.IPAddr) bool { return a.IP.Equal(b.IP) }) {
+					addrs = append(addrs, a)
+				}
+			}
+			if delay == nil {
+				delay = time.After(resolutionDelay)
+			}
+		case <-delay:
+			return addrs, nil
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return addrs, nil
+}
+
+// interleaveFamilies returns the addrs usable with network, alternating
+// between IPv6 and IPv4 starting with IPv6 (RFC 8305 §4), while keeping the
+// order within each family.
+func interleaveFamilies(network string, addrs []net.IPAddr) []net.IPAddr {
+	var v4, v6 []net.IPAddr
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			if !strings.HasSuffix(network, "6") {
+				v4 = append(v4, a)
+			}
+		} else if a.IP.To16() != nil {
+			if !strings.HasSuffix(network, "4") {
+				v6 = append(v6, a)
+			}
+		}
+	}
+
+	out := make([]net.IPAddr, 0, len(v4)+len(v6))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			out = append(out, v6[i])
+		}
+		if i < len(v4) {
+			out = append(out, v4[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package simplemdns
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestAddrsFromMsgZone(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil || len(ifaces) == 0 {
+		t.Skip("no interfaces")
+	}
+	iface := ifaces[0]
+
+	msg := new(dns.Msg)
+	msg.Answer = []dns.RR{
+		&dns.SRV{Hdr: dns.RR_Header{Name: "Web._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET}, Port: 80, Target: "host.local."},
+	}
+	msg.Extra = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("192.168.1.10")},
+		&dns.AAAA{Hdr: dns.RR_Header{Name: "HOST.local.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET}, AAAA: net.ParseIP("fe80::1")},
+		&dns.AAAA{Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET}, AAAA: net.ParseIP("2001:db8::1")},
+		&dns.A{Hdr: dns.RR_Header{Name: "other.local.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("192.168.1.11")},
+	}
+
+	tests := []struct {
+		name string
+		m    *ReceivedMsg
+		zone string
+	}{
+		{"interface", &ReceivedMsg{Msg: msg, IfIndex: iface.Index}, iface.Name},
+		{"sender zone", &ReceivedMsg{Msg: msg, Src: &net.UDPAddr{IP: net.ParseIP("fe80::2"), Port: 5353, Zone: "eth9"}}, "eth9"},
+		{"unknown", &ReceivedMsg{Msg: msg}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := addrsFromMsg(tt.m, "host.local.")
+			want := []net.IPAddr{
+				{IP: net.ParseIP("192.168.1.10")},
+				{IP: net.ParseIP("fe80::1"), Zone: tt.zone},
+				{IP: net.ParseIP("2001:db8::1")},
+			}
+			if !slices.EqualFunc(got, want, func(a, b net.IPAddr) bool { return a.IP.Equal(b.IP) && a.Zone == b.Zone }) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSelectSRV(t *testing.T) {
+	srv := func(name string, priority, weight uint16, target string) dns.RR {
+		return &dns.SRV{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET}, Priority: priority, Weight: weight, Target: target}
+	}
+	const name = "Web._http._tcp.local."
+
+	tests := []struct {
+		name    string
+		rrs     []dns.RR
+		targets []string // acceptable picks; nil for none
+	}{
+		{"none", nil, nil},
+		{"other name", []dns.RR{srv("Other._http._tcp.local.", 0, 0, "a.local.")}, nil},
+		{"lowest priority", []dns.RR{srv(name, 10, 0, "a.local."), srv(name, 5, 0, "b.local."), srv(name, 20, 0, "c.local.")}, []string{"b.local."}},
+		{"case-insensitive name", []dns.RR{srv("WEB._http._tcp.local.", 0, 0, "a.local.")}, []string{"a.local."}},
+		{"zero weights", []dns.RR{srv(name, 0, 0, "a.local."), srv(name, 0, 0, "b.local.")}, []string{"a.local.", "b.local."}},
+		{"weighted", []dns.RR{srv(name, 0, 0, "a.local."), srv(name, 0, 10, "b.local.")}, []string{"b.local."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 20 {
+				got := selectSRV(tt.rrs, name)
+				if tt.targets == nil {
+					if got != nil {
+						t.Fatalf("got %v, want nil", got)
+					}
+					continue
+				}
+				if got == nil || !slices.Contains(tt.targets, got.Target) {
+					t.Fatalf("got %v, want one of %v", got, tt.targets)
+				}
+			}
+		})
+	}
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	addrs := func(ips ...string) []net.IPAddr {
+		var out []net.IPAddr
+		for _, ip := range ips {
+			out = append(out, net.IPAddr{IP: net.ParseIP(ip)})
+		}
+		return out
+	}
+	in := addrs("192.0.2.1", "192.0.2.2", "192.0.2.3", "2001:db8::1", "2001:db8::2")
+
+	tests := []struct {
+		network string
+		want    []net.IPAddr
+	}{
+		{"tcp", addrs("2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "192.0.2.3")},
+		{"tcp4", addrs("192.0.2.1", "192.0.2.2", "192.0.2.3")},
+		{"tcp6", addrs("2001:db8::1", "2001:db8::2")},
+		{"udp", addrs("2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "192.0.2.3")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			got := interleaveFamilies(tt.network, in)
+			if !slices.EqualFunc(got, tt.want, func(a, b net.IPAddr) bool { return a.IP.Equal(b.IP) }) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
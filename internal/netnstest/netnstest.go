@@ -0,0 +1,186 @@
+//go:build linux
+
+// Package netnstest builds throwaway Linux network namespaces connected by a
+// veth pair, so integration tests can exercise real multicast between two
+// transports without touching the host network.
+//
+// It needs root (CAP_NET_ADMIN and CAP_SYS_ADMIN) and the ip(8) tool; NewPair
+// skips the test when either is missing.
+//
+//	pair := netnstest.NewPair(t)
+//	var a transport.Transport
+//	err := pair.A.Do(func() (err error) {
+//		a, err = transport.New(transport.Options{BindTo: transport.BindMDNSPort})
+//		return err
+//	})
+package netnstest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Namespace is one end of a Pair.
+type Namespace struct {
+	Name  string       // network namespace name, as listed by "ip netns"
+	Iface string       // veth interface name inside the namespace
+	Addr4 netip.Prefix // IPv4 address assigned to Iface
+	Addr6 netip.Prefix // IPv6 ULA address assigned to Iface
+}
+
+// Pair is two namespaces whose veth interfaces are linked to each other.
+type Pair struct {
+	A, B *Namespace
+}
+
+var seq atomic.Uint32
+
+// NewPair creates two namespaces linked by a veth pair, each with an IPv4 and
+// an IPv6 address on the shared link. Everything is removed on test cleanup.
+func NewPair(tb testing.TB) *Pair {
+	tb.Helper()
+
+	if os.Geteuid() != 0 {
+		tb.Skip("netnstest: requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		tb.Skip("netnstest: ip(8) not found")
+	}
+
+	id := fmt.Sprintf("%d-%d", os.Getpid()%100000, seq.Add(1))
+	p := &Pair{
+		A: &Namespace{
+			Name:  "smdns-a-" + id,
+			Iface: "sma" + id,
+			Addr4: netip.MustParsePrefix("10.253.0.1/24"),
+			Addr6: netip.MustParsePrefix("fd53::1/64"),
+		},
+		B: &Namespace{
+			Name:  "smdns-b-" + id,
+			Iface: "smb" + id,
+			Addr4: netip.MustParsePrefix("10.253.0.2/24"),
+			Addr6: netip.MustParsePrefix("fd53::2/64"),
+		},
+	}
+
+	for _, ns := range []*Namespace{p.A, p.B} {
+		if err := ip("netns", "add", ns.Name); err != nil {
+			tb.Skipf("netnstest: cannot create namespace: %v", err)
+		}
+		tb.Cleanup(func() { ip("netns", "del", ns.Name) })
+
+		// Skip duplicate address detection so IPv6 is usable immediately.
+		if err := ip("netns", "exec", ns.Name, "sysctl", "-qw", "net.ipv6.conf.default.accept_dad=0"); err != nil {
+			tb.Fatalf("netnstest: %v", err)
+		}
+	}
+
+	// Deleting either namespace destroys the veth pair.
+	if err := ip("link", "add", p.A.Iface, "netns", p.A.Name, "type", "veth",
+		"peer", "name", p.B.Iface, "netns", p.B.Name); err != nil {
+		tb.Fatalf("netnstest: %v", err)
+	}
+
+	for _, ns := range []*Namespace{p.A, p.B} {
+		cmds := [][]string{
+			{"-n", ns.Name, "link", "set", "lo", "up"},
+			{"-n", ns.Name, "addr", "add", ns.Addr4.String(), "dev", ns.Iface},
+			{"-n", ns.Name, "addr", "add", ns.Addr6.String(), "dev", ns.Iface, "nodad"},
+			{"-n", ns.Name, "link", "set", ns.Iface, "up"},
+		}
+		for _, args := range cmds {
+			if err := ip(args...); err != nil {
+				tb.Fatalf("netnstest: %v", err)
+			}
+		}
+	}
+
+	for _, ns := range []*Namespace{p.A, p.B} {
+		if err := ns.waitRunning(); err != nil {
+			tb.Fatalf("netnstest: %v", err)
+		}
+	}
+
+	return p
+}
+
+// waitRunning waits for the veth to report carrier. Occasionally one end of a
+// freshly created pair stays in NO-CARRIER; cycling the link clears it.
+func (ns *Namespace) waitRunning() error {
+	deadline := time.Now().Add(3 * time.Second)
+	for attempt := 0; time.Now().Before(deadline); attempt++ {
+		iface, err := ns.Interface()
+		if err != nil {
+			return err
+		}
+		if iface.Flags&net.FlagRunning != 0 {
+			return nil
+		}
+		if attempt > 0 && attempt%5 == 0 {
+			ip("-n", ns.Name, "link", "set", ns.Iface, "down")
+			ip("-n", ns.Name, "link", "set", ns.Iface, "up")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("%s in %s did not come up", ns.Iface, ns.Name)
+}
+
+// Interface returns the veth interface of ns as seen from inside it.
+func (ns *Namespace) Interface() (*net.Interface, error) {
+	var iface *net.Interface
+	err := ns.Do(func() (err error) {
+		iface, err = net.InterfaceByName(ns.Iface)
+		return err
+	})
+	return iface, err
+}
+
+// Do runs fn on an OS thread switched into ns. Sockets created by fn stay in
+// ns after it returns, so a transport created inside Do keeps working on the
+// namespace's network from any goroutine. That does not hold for what the
+// transport does later on its own goroutines outside ns: the interface
+// watcher lists interfaces and opens sockets in the host namespace, so
+// transports created here must not set WatchInterfaces, and clients and
+// responders need StaticIfaces.
+func (ns *Namespace) Do(fn func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		// The thread is never unlocked: when this goroutine exits the runtime
+		// discards the thread instead of reusing it in the wrong namespace.
+		runtime.LockOSThread()
+
+		f, err := os.Open("/run/netns/" + ns.Name)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer f.Close()
+
+		if err := unix.Setns(int(f.Fd()), unix.CLONE_NEWNET); err != nil {
+			errCh <- fmt.Errorf("setns %s: %w", ns.Name, err)
+			return
+		}
+		errCh <- fn()
+	}()
+	return <-errCh
+}
+
+func ip(args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("ip", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ip %v: %w: %s", args, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
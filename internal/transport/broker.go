@@ -0,0 +1,432 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Broker mode lets several processes on one host share port 5353 without a
+// system daemon. The first process binds the port and listens on a Unix
+// socket; later processes connect to it and exchange raw packets over framed
+// stream messages:
+//
+//	uint16 length | kind | uint8 addr length | addr | packet
+//
+// The length covers everything after itself. addr is the UDP peer in
+// host:port form, empty for multicast.
+
+const (
+	frameMulticast byte = iota + 1 // peer -> broker: multicast packet
+	frameUnicast                   // peer -> broker: unicast packet to addr
+	frameReceived                  // broker -> peer: packet received from addr
+)
+
+const (
+	brokerWriteTimeout = time.Second
+	// brokerPeerQueueLen is how many received packets may wait for a slow
+	// peer before the broker gives up on it.
+	brokerPeerQueueLen = 256
+)
+
+// errBrokerRunning reports that another process already serves the broker
+// socket, possibly while sharing port 5353 with us.
+var errBrokerRunning = errors.New("broker socket is already served")
+
+func encodeFrame(kind byte, addr string, b []byte) ([]byte, error) {
+	n := 1 + 1 + len(addr) + len(b)
+	if len(addr) > 0xff || n > 0xffff {
+		return nil, errors.New("broker frame too large")
+	}
+	buf := make([]byte, 0, 2+n)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	buf = append(buf, kind, byte(len(addr)))
+	buf = append(buf, addr...)
+	buf = append(buf, b...)
+	return buf, nil
+}
+
+func writeFrame(w io.Writer, kind byte, addr string, b []byte) error {
+	buf, err := encodeFrame(kind, addr, b)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader) (kind byte, addr string, b []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return
+	}
+	if len(buf) < 2 || len(buf) < 2+int(buf[1]) {
+		return 0, "", nil, errors.New("malformed broker frame")
+	}
+	kind, alen := buf[0], int(buf[1])
+	return kind, string(buf[2 : 2+alen]), buf[2+alen:], nil
+}
+
+// newBrokered becomes the broker for opts.Broker if port 5353 is free, and
+// otherwise connects to the existing broker as a peer. With SharePort the
+// port may be bound alongside a running broker, which is then joined too.
+func newBrokered(opts Options) (Transport, error) {
+	opts.BindTo = BindMDNSPort
+
+	t, err := newConn(opts)
+	if err == nil {
+		c := t.(*mdnsConn)
+		err = c.startBroker(opts.Broker)
+		if err == nil {
+			return c, nil
+		}
+		c.Close()
+		if !errors.Is(err, errBrokerRunning) {
+			return nil, err
+		}
+	}
+
+	logger.Debug("could not bind mDNS port; connecting to broker", slog.String("path", opts.Broker), slog.Any("error", err))
+	p, perr := newBrokerPeer(opts)
+	if perr != nil {
+		return nil, errors.Join(err, perr)
+	}
+	return p, nil
+}
+
+type broker struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	peers  map[net.Conn]chan []byte // frames queued for each peer's writer
+	closed bool
+}
+
+func (c *mdnsConn) startBroker(path string) error {
+	ln, err := net.Listen("unix", path)
+	if err != nil && errors.Is(err, syscall.EADDRINUSE) {
+		// Remove a socket left behind by a crashed broker, but never steal
+		// one that is still served: with SharePort we may hold port 5353
+		// alongside it.
+		if conn, derr := net.Dial("unix", path); derr == nil {
+			conn.Close()
+			return errBrokerRunning
+		}
+		os.Remove(path)
+		ln, err = net.Listen("unix", path)
+	}
+	if err != nil {
+		return fmt.Errorf("listen on broker socket: %w", err)
+	}
+
+	b := &broker{ln: ln, peers: make(map[net.Conn]chan []byte)}
+	c.broker = b
+
+	c.wg.Go(func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			out := make(chan []byte, brokerPeerQueueLen)
+			b.mu.Lock()
+			if b.closed {
+				b.mu.Unlock()
+				conn.Close()
+				return
+			}
+			b.peers[conn] = out
+			b.mu.Unlock()
+			logger.Debug("broker peer connected")
+
+			c.wg.Go(func() { c.servePeer(conn) })
+			c.wg.Go(func() { b.writePeer(conn, out) })
+		}
+	})
+
+	logger.Debug("acting as mDNS broker", slog.String("path", path))
+	return nil
+}
+
+// servePeer sends packets submitted by a peer until it disconnects.
+func (c *mdnsConn) servePeer(conn net.Conn) {
+	defer c.broker.drop(conn)
+
+	r := bufio.NewReader(conn)
+	for {
+		kind, addr, b, err := readFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Debug("broker peer read failed", slog.Any("error", err))
+			}
+			return
+		}
+
+		switch kind {
+		case frameMulticast:
+			err = c.send(b)
+		case frameUnicast:
+			var ua *net.UDPAddr
+			if ua, err = net.ResolveUDPAddr("udp", addr); err == nil {
+				err = c.sendTo(b, ua)
+			}
+		default:
+			err = fmt.Errorf("unexpected broker frame kind %d", kind)
+		}
+		if err != nil {
+			logger.Debug("failed to send packet for broker peer", slog.Any("error", err))
+		}
+	}
+}
+
+// relay queues a received packet for every peer without waiting for any of
+// them; a peer whose queue is full is dropped. A nil *broker relays nothing.
+func (b *broker) relay(from *net.UDPAddr, pkt []byte) {
+	if b == nil {
+		return
+	}
+	frame, err := encodeFrame(frameReceived, from.String(), pkt)
+	if err != nil {
+		logger.Debug("cannot relay packet to broker peers", slog.Any("error", err))
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for conn, out := range b.peers {
+		select {
+		case out <- frame:
+		default:
+			logger.Debug("dropping broker peer that fell behind")
+			b.dropLocked(conn)
+		}
+	}
+}
+
+// writePeer writes the frames queued for conn until it is dropped.
+func (b *broker) writePeer(conn net.Conn, out <-chan []byte) {
+	for frame := range out {
+		conn.SetWriteDeadline(time.Now().Add(brokerWriteTimeout))
+		if _, err := conn.Write(frame); err != nil {
+			logger.Debug("dropping broker peer after write failure", slog.Any("error", err))
+			b.drop(conn)
+			return
+		}
+	}
+}
+
+func (b *broker) drop(conn net.Conn) {
+	b.mu.Lock()
+	b.dropLocked(conn)
+	b.mu.Unlock()
+}
+
+// dropLocked disconnects conn, if it is still a peer. b.mu must be held.
+func (b *broker) dropLocked(conn net.Conn) {
+	out, ok := b.peers[conn]
+	if !ok {
+		return
+	}
+	delete(b.peers, conn)
+	close(out)
+	conn.Close()
+}
+
+func (b *broker) close() {
+	if b == nil {
+		return
+	}
+	b.ln.Close()
+	b.mu.Lock()
+	b.closed = true
+	for conn := range b.peers {
+		b.dropLocked(conn)
+	}
+	b.mu.Unlock()
+}
+
+// brokerPeer is a Transport that sends and receives through another process's
+// broker instead of its own sockets.
+type brokerPeer struct {
+	conn net.Conn
+	wmu  sync.Mutex
+
+	msgs   chan *ReceivedMsg
+	errs   chan error
+	onDrop func(*dns.Msg)
+
+	compliance Compliance
+	truncated  truncatedQueries
+	filter     func(*ReceivedMsg) bool
+	observe    func(*ReceivedMsg)
+
+	dropped atomic.Uint64
+	invalid atomic.Uint64
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func newBrokerPeer(opts Options) (*brokerPeer, error) {
+	conn, err := net.Dial("unix", opts.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("connect to broker: %w", err)
+	}
+
+	p := &brokerPeer{
+		conn:   conn,
+		msgs:   make(chan *ReceivedMsg, opts.MsgsChBufSize),
+		errs:   make(chan error, 8),
+		onDrop: opts.OnDrop,
+
+		compliance: opts.Compliance,
+		filter:     opts.Filter,
+		observe:    opts.Observe,
+	}
+	p.wg.Go(p.recvLoop)
+
+	logger.Debug("connected to mDNS broker", slog.String("path", opts.Broker))
+	return p, nil
+}
+
+func (p *brokerPeer) recvLoop() {
+	r := bufio.NewReader(p.conn)
+	for {
+		kind, addr, b, err := readFrame(r)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// The broker went away; there is no takeover, so report and stop.
+			logger.Warn("broker connection lost", slog.Any("error", err))
+			err = fmt.Errorf("broker connection lost: %w", err)
+			select {
+			case p.errs <- err:
+			default:
+			}
+			return
+		}
+		if kind != frameReceived {
+			continue
+		}
+
+		msg := new(dns.Msg)
+		if err := msg.Unpack(b); err != nil {
+			logger.Warn("error unpacking DNS message", slog.String("from", addr), slog.Int("size", len(b)), slog.Any("error", err))
+			continue
+		}
+
+		from, _ := net.ResolveUDPAddr("udp", addr)
+		if err := validateMsg(msg, from, p.compliance, &p.truncated); err != nil {
+			p.invalid.Add(1)
+			logger.Debug("ignoring invalid DNS message", slog.String("from", addr), slog.Any("reason", err))
+			continue
+		}
+
+		logger.Debug("received DNS message via broker",
+			slog.String("from", addr),
+			slog.Int("questions", len(msg.Question)),
+			slog.Int("answers", len(msg.Answer)),
+			slog.Any("names", msgNames(msg)))
+
+		// the broker relays only the sender; the receiving interface and
+		// destination stay unknown
+		rm := &ReceivedMsg{Msg: msg, Src: from}
+		if p.filter != nil && !p.filter(rm) {
+			continue
+		}
+
+		if p.observe != nil {
+			p.observe(rm)
+		}
+
+		select {
+		case p.msgs <- rm:
+		default:
+			p.dropped.Add(1)
+			logger.Debug("dropping DNS message due to full channel")
+			if p.onDrop != nil {
+				p.onDrop(msg)
+			}
+		}
+	}
+}
+
+func (p *brokerPeer) Messages() <-chan *ReceivedMsg {
+	return p.msgs
+}
+
+func (p *brokerPeer) Errors() <-chan error {
+	return p.errs
+}
+
+func (p *brokerPeer) SendMsg(msg *dns.Msg) error {
+	return p.write(frameMulticast, "", msg)
+}
+
+func (p *brokerPeer) SendMsgTo(msg *dns.Msg, addr *net.UDPAddr) error {
+	return p.write(frameUnicast, addr.String(), msg)
+}
+
+func (p *brokerPeer) SetInterfaces([]net.Interface) error {
+	return errors.New("interfaces are managed by the broker")
+}
+
+func (p *brokerPeer) SyscallConns(IPVersion) ([]syscall.RawConn, error) {
+	return nil, errors.New("sockets are owned by the broker")
+}
+
+func (p *brokerPeer) write(kind byte, addr string, msg *dns.Msg) error {
+	pack := msg.Pack
+	if kind == frameMulticast {
+		pack = func() ([]byte, error) { return packMulticast(msg) }
+	}
+	b, err := pack()
+	if err != nil {
+		return err
+	}
+
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(brokerWriteTimeout))
+	return writeFrame(p.conn, kind, addr, b)
+}
+
+func (p *brokerPeer) Stats() Stats {
+	return Stats{
+		MsgsDropped: p.dropped.Load(),
+		MsgsInvalid: p.invalid.Load(),
+	}
+}
+
+func (p *brokerPeer) Status() Status {
+	// the broker owns the mDNS port on our behalf
+	return Status{
+		BindTo: BindMDNSPort,
+	}
+}
+
+func (p *brokerPeer) Close() (err error) {
+	p.closeOnce.Do(func() {
+		err = p.conn.Close()
+		p.wg.Wait()
+		close(p.msgs)
+		close(p.errs)
+	})
+	return
+}
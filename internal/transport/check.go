@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Helpers for environment diagnostics. They use short-lived sockets and never
+// touch a running transport.
+
+// MulticastInterfaces returns the interfaces that are up and multicast-capable.
+func MulticastInterfaces() ([]net.Interface, error) {
+	return multicastInterfaces()
+}
+
+// InterfaceSupports reports whether iface has an address of version v.
+func InterfaceSupports(iface *net.Interface, v IPVersion) bool {
+	ok, _ := interfaceSupports(iface, v)
+	return ok
+}
+
+// PortOwners returns the processes that appear to hold UDP port, best-effort.
+func PortOwners(port int) []PortOwner {
+	return portOwners(port)
+}
+
+// CheckJoin tries to join the mDNS group of version v (IPv4 or IPv6) on iface
+// using a throwaway socket.
+func CheckJoin(iface *net.Interface, v IPVersion) error {
+	switch v {
+	case IPv4:
+		conn, err := net.ListenUDP("udp4", zeroAddrUDP4)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return ipv4.NewPacketConn(conn).JoinGroup(iface, mdnsGaddrUDP4)
+	case IPv6:
+		conn, err := net.ListenUDP("udp6", zeroAddrUDP6)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return ipv6.NewPacketConn(conn).JoinGroup(iface, mdnsGaddrUDP6)
+	}
+	return errors.New("invalid IP version")
+}
@@ -1,8 +1,11 @@
 package transport
 
 import (
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/miekg/dns"
 )
@@ -10,7 +13,26 @@ import (
 type mdnsConn struct {
 	*socket
 
-	msgs chan *dns.Msg
+	msgs    chan *ReceivedMsg
+	onDrop  func(*dns.Msg)
+	filter  func(*ReceivedMsg) bool
+	observe func(*ReceivedMsg)
+
+	logMessages bool
+
+	acceptIfaces map[int]struct{} // nil accepts packets from every interface
+
+	dropped atomic.Uint64
+	invalid atomic.Uint64
+
+	errs chan error
+	done chan struct{}
+
+	broker *broker // non-nil when relaying for other processes
+
+	bindTo     BindStrategy
+	compliance Compliance
+	truncated  truncatedQueries
 
 	wg        sync.WaitGroup
 	closeOnce sync.Once
@@ -24,23 +46,99 @@ func newConn(opts Options) (Transport, error) {
 
 	c := &mdnsConn{
 		socket: socket,
-		msgs:   make(chan *dns.Msg, opts.MsgsChBufSize),
+		msgs:   make(chan *ReceivedMsg, opts.MsgsChBufSize),
+		onDrop: opts.OnDrop,
+		errs:   make(chan error, 8),
+		done:   make(chan struct{}),
+
+		logMessages: opts.LogMessages,
+		bindTo:      opts.BindTo,
+		compliance:  opts.Compliance,
+		filter:      opts.Filter,
+		observe:     opts.Observe,
+	}
+	if len(opts.AcceptIfaces) > 0 {
+		c.acceptIfaces = make(map[int]struct{}, len(opts.AcceptIfaces))
+		for _, idx := range opts.AcceptIfaces {
+			c.acceptIfaces[idx] = struct{}{}
+		}
 	}
 
 	c.startRecvLoop(opts.UDPRecvBufSize)
+	c.startWatchdog(opts.StallTimeout)
+	if opts.WatchInterfaces {
+		c.startInterfaceWatch(opts.OnInterfacesChanged)
+	}
 
 	return c, nil
 }
 
 func (c *mdnsConn) Close() (err error) {
 	c.closeOnce.Do(func() {
+		c.broker.close()
 		err = c.socket.close()
+		close(c.done)
 		c.wg.Wait()
 		close(c.msgs)
+		close(c.errs)
 	})
 	return
 }
 
+// accepts reports whether a packet received on interface ifIndex should be
+// delivered. Packets whose interface is unknown (no control message, e.g. on
+// Windows) are always accepted.
+func (c *mdnsConn) accepts(ifIndex int) bool {
+	if c.acceptIfaces == nil || ifIndex == 0 {
+		return true
+	}
+	_, ok := c.acceptIfaces[ifIndex]
+	return ok
+}
+
+func (c *mdnsConn) Errors() <-chan error {
+	return c.errs
+}
+
+// reportError delivers err on the errors channel, dropping it if nobody keeps
+// up with the channel.
+func (c *mdnsConn) reportError(err error) {
+	select {
+	case c.errs <- err:
+	default:
+		logger.Debug("dropping transport error due to full channel", slog.Any("error", err))
+	}
+}
+
+func (c *mdnsConn) Stats() Stats {
+	return Stats{
+		MsgsDropped:  c.dropped.Load(),
+		MsgsInvalid:  c.invalid.Load(),
+		SendRetries:  c.sendRetries.Load(),
+		SendFailures: c.sendFailures.Load(),
+	}
+}
+
+func (c *mdnsConn) Status() Status {
+	return Status{
+		BindTo:    c.bindTo,
+		IPVersion: c.families(),
+		Failed:    c.failures(),
+		NoGroup:   c.groupless(),
+	}
+}
+
+func (c *mdnsConn) SetInterfaces(ifaces []net.Interface) error {
+	c.ifMu.Lock()
+	c.discovered = false
+	c.ifMu.Unlock()
+	return c.setInterfaces(ifaces)
+}
+
+func (c *mdnsConn) SyscallConns(family IPVersion) ([]syscall.RawConn, error) {
+	return c.syscallConns(family)
+}
+
 func (c *mdnsConn) send(b []byte) error {
 	return c.socket.multicast(b)
 }
@@ -27,14 +27,43 @@ const (
 	IPv4And6 = IPv4 | IPv6             // 0b11
 )
 
+func (v IPVersion) String() string {
+	switch v {
+	case IPv4:
+		return "IPv4"
+	case IPv6:
+		return "IPv6"
+	case IPv4And6:
+		return "IPv4+IPv6"
+	default:
+		return "none"
+	}
+}
+
 type BindStrategy int
 
 const (
 	BindZeroAddr BindStrategy = iota + 1
 	BindMDNSPort
 	BindMDNSGaddr
+	BindAuto // BindMDNSPort, falling back to BindZeroAddr if the port is unavailable
 )
 
+func (b BindStrategy) String() string {
+	switch b {
+	case BindZeroAddr:
+		return "zero-addr"
+	case BindMDNSPort:
+		return "mdns-port"
+	case BindMDNSGaddr:
+		return "mdns-gaddr"
+	case BindAuto:
+		return "auto"
+	default:
+		return "unknown"
+	}
+}
+
 func bindAddrs(strategy BindStrategy) (udp4addr, udp6addr *net.UDPAddr) {
 	switch strategy {
 	case BindZeroAddr:
@@ -49,3 +78,14 @@ func bindAddrs(strategy BindStrategy) (udp4addr, udp6addr *net.UDPAddr) {
 	}
 	return
 }
+
+// Compliance selects how strictly RFC 6762 rules are enforced on received
+// traffic.
+type Compliance int
+
+const (
+	// Lenient tolerates common deviations by cheap or old implementations.
+	Lenient Compliance = iota
+	// Strict enforces the rules, e.g. for certification-style testing.
+	Strict
+)
@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ContainerError reports that the process appears to run in a container with
+// its own network namespace, where multicast only reaches the container
+// bridge and never the LAN.
+type ContainerError struct {
+	Runtime  string   // "kubernetes", "docker", "podman", "containerd", "lxc" or "container" if unknown
+	Evidence []string // what gave it away, e.g. "/.dockerenv exists"
+}
+
+func (e *ContainerError) Error() string {
+	return fmt.Sprintf("running in a %s container without host networking (%s); mDNS multicast will not reach the LAN: "+
+		"use host networking (docker run --network host, or hostNetwork: true in Kubernetes) or run an mDNS reflector on the host",
+		e.Runtime, strings.Join(e.Evidence, "; "))
+}
+
+// cgroupMarkers map substrings of /proc/1/cgroup to the runtime they reveal.
+var cgroupMarkers = []struct{ marker, runtime string }{
+	{"kubepods", "kubernetes"},
+	{"docker", "docker"},
+	{"libpod", "podman"},
+	{"containerd", "containerd"},
+	{"lxc", "lxc"},
+}
+
+// DetectContainer returns a *ContainerError if the process seems to run in a
+// container with an isolated network, and nil otherwise, including for
+// containers on host networking. It is a heuristic: the runtime is recognized
+// by the files and environment it leaves behind, and host networking by the
+// interfaces in view.
+func DetectContainer() *ContainerError {
+	e := &ContainerError{}
+	found := func(runtime, evidence string) {
+		if e.Runtime == "" {
+			e.Runtime = runtime
+		}
+		e.Evidence = append(e.Evidence, evidence)
+	}
+
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		found("kubernetes", "KUBERNETES_SERVICE_HOST is set")
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		found("docker", "/.dockerenv exists")
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		found("podman", "/run/.containerenv exists")
+	}
+	if b, err := os.ReadFile("/proc/1/cgroup"); err == nil {
+		for _, m := range cgroupMarkers {
+			if strings.Contains(string(b), m.marker) {
+				found(m.runtime, "/proc/1/cgroup mentions "+m.marker)
+				break
+			}
+		}
+	}
+	if v := os.Getenv("container"); v != "" {
+		// set by podman, systemd-nspawn and LXC
+		found("container", "container="+v+" is set")
+	}
+
+	if len(e.Evidence) == 0 {
+		return nil
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil || hostNetworking(ifaces) {
+		return nil
+	}
+	return e
+}
+
+// hostNetworking reports whether ifaces look like the host's rather than a
+// container's own namespace, which typically has just lo and one veth peer
+// named eth0. Bridges and veths of other containers, or several interfaces
+// that are up, are only visible from the host namespace.
+func hostNetworking(ifaces []net.Interface) bool {
+	var n int
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if IsVirtualInterface(&iface) {
+			return true
+		}
+		n++
+	}
+	return n > 1
+}
+
+var containerWarnOnce sync.Once
+
+// warnIfContainer logs a warning, once per process, if DetectContainer finds
+// an isolated container network.
+func warnIfContainer() {
+	containerWarnOnce.Do(func() {
+		if err := DetectContainer(); err != nil {
+			logger.Warn("mDNS is likely confined to the container network", slog.String("runtime", err.Runtime), slog.Any("error", error(err)))
+		}
+	})
+}
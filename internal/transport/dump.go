@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// dumper writes hex dumps of raw packets for diagnosing interop problems. A nil
+// *dumper is valid and dumps nothing.
+type dumper struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newDumper(w io.Writer) *dumper {
+	if w == nil {
+		return nil
+	}
+	return &dumper{w: w}
+}
+
+// dump writes one packet. dir is "send" or "recv"; iface may be empty when the
+// interface is unknown.
+func (d *dumper) dump(dir, network, iface string, peer *net.UDPAddr, b []byte) {
+	if d == nil {
+		return
+	}
+	if iface == "" {
+		iface = "-"
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(d.w, "%s %s %s %s peer=%s len=%d\n",
+		time.Now().Format(time.RFC3339Nano), dir, network, iface, peer, len(b))
+	io.WriteString(d.w, hex.Dump(b))
+}
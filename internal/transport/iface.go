@@ -1,6 +1,48 @@
 package transport
 
-import "net"
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// virtualIfacePrefixes are name prefixes of interfaces created by container
+// runtimes, hypervisors and VPNs. Joining the mDNS group on them rarely does
+// anything useful and is the most common source of failed joins.
+var virtualIfacePrefixes = []string{
+	"docker", "br-", "veth", "virbr", "vnet", "cni", "flannel", "cali", "lxc", "lxdbr", "podman",
+	"tun", "tap", "utun", "wg", "zt", "tailscale",
+	"vmnet", "vboxnet", "awdl", "llw", "anpi", "bridge",
+}
+
+// IsVirtualInterface reports whether iface looks like a virtual (container,
+// VM or VPN) interface, judging by its name.
+func IsVirtualInterface(iface *net.Interface) bool {
+	for _, p := range virtualIfacePrefixes {
+		if strings.HasPrefix(iface.Name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutVirtual drops virtual interfaces from ifaces, unless that would leave
+// nothing, in which case they are all we have and ifaces is returned as is.
+func withoutVirtual(ifaces []net.Interface) []net.Interface {
+	phys := make([]net.Interface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		if IsVirtualInterface(&iface) {
+			logger.Debug("excluding virtual interface by default", slog.String("interface", iface.Name))
+			continue
+		}
+		phys = append(phys, iface)
+	}
+	if len(phys) == 0 {
+		return ifaces
+	}
+	return phys
+}
 
 func multicastInterfaces() ([]net.Interface, error) {
 	ifaces, err := net.Interfaces()
@@ -49,3 +91,43 @@ func interfaceSupports(iface *net.Interface, v IPVersion) (supports bool, err er
 
 	return
 }
+
+// interfaceByAddr returns the interface that has ip assigned.
+func interfaceByAddr(ip net.IP) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return &iface, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no interface has address %s", ip)
+}
+
+// detectIPVersion returns the address families present on ifaces, so that an
+// IPv6-only host doesn't open a useless IPv4 socket (and vice versa). It falls
+// back to IPv4And6 when nothing can be determined.
+func detectIPVersion(ifaces []net.Interface) IPVersion {
+	var v IPVersion
+	for _, iface := range ifaces {
+		if ok, _ := interfaceSupports(&iface, IPv4); ok {
+			v |= IPv4
+		}
+		if ok, _ := interfaceSupports(&iface, IPv6); ok {
+			v |= IPv6
+		}
+	}
+	if v == 0 {
+		v = IPv4And6
+	}
+	logger.Debug("detected IP version", slog.Bool("ipv4", v&IPv4 != 0), slog.Bool("ipv6", v&IPv6 != 0))
+	return v
+}
@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+)
+
+// interfaces returns the current interface set. The returned slice must not
+// be modified.
+func (s *socket) interfaces() []net.Interface {
+	s.ifMu.RLock()
+	defer s.ifMu.RUnlock()
+	return s.ifaces
+}
+
+// setInterfaces replaces the set of interfaces the mDNS group is joined on
+// and multicasts are sent on. The sockets of interfaces no longer in the set
+// are closed, which leaves the group there, and sockets are opened on the new
+// ones. Interfaces that lacked an address of a family get a socket once they
+// have one, and kept sockets that could not join the group retry, as do
+// families that could not be set up before, e.g. IPv6 on a host that booted
+// with IPv4 only. Unless
+// bestEffort is set, an error is returned if no group could be joined at
+// all; the new set is in effect regardless.
+func (s *socket) setInterfaces(ifaces []net.Interface) error {
+	if len(ifaces) == 0 {
+		return errors.New("no interfaces given")
+	}
+	ifaces = slices.Clone(ifaces)
+
+	s.ifMu.Lock()
+	defer s.ifMu.Unlock()
+	if s.closed {
+		return net.ErrClosed
+	}
+
+	type key struct {
+		family IPVersion
+		index  int // 0 for a fallback socket
+	}
+	old := make(map[key]*ifaceConn, len(s.conns))
+	for _, ic := range s.conns {
+		k := key{ic.family, 0}
+		if ic.iface != nil {
+			k.index = ic.iface.Index
+		}
+		old[k] = ic
+	}
+
+	var conns []*ifaceConn
+	var joined4, joined6 int
+	for _, family := range []IPVersion{IPv4, IPv6} {
+		if s.wanted&family == 0 {
+			continue
+		}
+		adding := s.working&family == 0
+		if adding {
+			if err := s.checkPort(family); err != nil {
+				logger.Debug("cannot set up family yet", slog.String("family", family.String()), slog.Any("error", err))
+				continue
+			}
+		}
+		n := len(conns)
+		for i := range ifaces {
+			iface := &ifaces[i]
+			if supports, _ := interfaceSupports(iface, family); !supports {
+				continue
+			}
+			ic, ok := old[key{family, iface.Index}]
+			if ok {
+				delete(old, key{family, iface.Index})
+				ic.join()
+			} else {
+				var err error
+				if ic, err = s.openConn(family, iface); err != nil {
+					logger.Debug("failed to open socket; skipping interface", slog.String("family", family.String()), slog.String("interface", iface.Name), slog.Any("error", err))
+					continue
+				}
+				s.serve(ic)
+			}
+			conns = append(conns, ic)
+			if ic.joined && family == IPv4 {
+				joined4++
+			} else if ic.joined {
+				joined6++
+			}
+		}
+
+		if adding {
+			s.addFamily(family, conns[n:])
+			if s.working&family == 0 {
+				conns = conns[:n]
+			}
+			continue
+		}
+		if len(conns) > n {
+			continue
+		}
+		// keep the family receiving and able to unicast on no interface
+		ic, ok := old[key{family, 0}]
+		if ok {
+			delete(old, key{family, 0})
+		} else {
+			var err error
+			if ic, err = s.openConn(family, nil); err != nil {
+				logger.Warn("failed to open socket", slog.String("family", family.String()), slog.Any("error", err))
+				continue
+			}
+			s.serve(ic)
+		}
+		conns = append(conns, ic)
+	}
+
+	for _, ic := range old {
+		ic.conn.Close()
+	}
+	s.ifaces, s.conns = ifaces, conns
+
+	s.noGroup = 0
+	if s.working&IPv4 != 0 && joined4 == 0 {
+		s.noGroup |= IPv4
+	}
+	if s.working&IPv6 != 0 && joined6 == 0 {
+		s.noGroup |= IPv6
+	}
+	logger.Info("interfaces changed", slog.Int("total", len(ifaces)), slog.Int("joined4", joined4), slog.Int("joined6", joined6))
+
+	if s.noGroup == s.working {
+		if !s.bestEffort {
+			return errors.New("no multicast group joined on any of the new interfaces")
+		}
+		logger.Warn("no multicast group joined on any of the new interfaces; mDNS may not work")
+	}
+	return nil
+}
+
+// addFamily starts running family, which was not set up before, on conns,
+// its new sockets, if any of them joined the group or bestEffort is set;
+// otherwise it closes them. s.ifMu must be held.
+func (s *socket) addFamily(family IPVersion, conns []*ifaceConn) {
+	if len(conns) == 0 {
+		return
+	}
+	if !s.bestEffort && !slices.ContainsFunc(conns, func(ic *ifaceConn) bool { return ic.joined }) {
+		logger.Debug("no multicast group joined for " + family.String() + "; not setting it up yet")
+		closeConns(conns)
+		return
+	}
+	s.working |= family
+	s.failed = slices.DeleteFunc(slices.Clone(s.failed), func(fe *FamilyError) bool { return fe.Family == family })
+	logger.Info("set up "+family.String()+" sockets", slog.Int("sockets", len(conns)))
+}
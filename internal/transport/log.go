@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"context"
+	"log/slog"
+)
+
+// logLevel is the minimum level the library logs at, applied on top of the
+// application's handler. It starts at Debug so that, by default, the
+// application's handler alone decides what is shown.
+var logLevel = func() *slog.LevelVar {
+	v := new(slog.LevelVar)
+	v.Set(slog.LevelDebug)
+	return v
+}()
+
+// TODO: replace this with a more flexible logging solution
+var logger = slog.New(&levelHandler{level: logLevel, h: slog.Default().Handler()}).With("lib", "simplemdns")
+
+// Logger returns the library's logger, so that other packages of the module
+// log with the same attributes and level.
+func Logger() *slog.Logger {
+	return logger
+}
+
+// SetLogLevel sets the minimum level of log records emitted by the library.
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
+}
+
+// levelHandler drops records below level before they reach h.
+type levelHandler struct {
+	level slog.Leveler
+	h     slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= h.level.Level() && h.h.Enabled(ctx, l)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.h.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, h: h.h.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, h: h.h.WithGroup(name)}
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// msgAttr returns the full contents of m as a structured "msg" attribute when
+// verbose message logging is enabled, and an empty attribute (which handlers
+// ignore) otherwise.
+func (c *mdnsConn) msgAttr(m *dns.Msg) slog.Attr {
+	if !c.logMessages {
+		return slog.Attr{}
+	}
+	return slog.Any("msg", msgLogValuer{m})
+}
+
+// msgLogValuer defers building the groups until a handler actually emits the
+// record, so disabled Debug logging stays cheap.
+type msgLogValuer struct{ m *dns.Msg }
+
+func (v msgLogValuer) LogValue() slog.Value {
+	m := v.m
+	return slog.GroupValue(
+		slog.Int("id", int(m.Id)),
+		slog.Bool("response", m.Response),
+		slog.Int("opcode", m.Opcode),
+		slog.Int("rcode", m.Rcode),
+		slog.Attr{Key: "question", Value: questionsValue(m.Question)},
+		slog.Attr{Key: "answer", Value: rrsValue(m.Answer)},
+		slog.Attr{Key: "authority", Value: rrsValue(m.Ns)},
+		slog.Attr{Key: "additional", Value: rrsValue(m.Extra)},
+	)
+}
+
+func questionsValue(qs []dns.Question) slog.Value {
+	attrs := make([]slog.Attr, 0, len(qs))
+	for i, q := range qs {
+		attrs = append(attrs, slog.Group(strconv.Itoa(i),
+			slog.String("name", q.Name),
+			slog.String("type", dns.Type(q.Qtype).String()),
+			slog.String("class", dns.Class(q.Qclass&^(1<<15)).String()),
+			slog.Bool("qu", q.Qclass&(1<<15) != 0),
+		))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func rrsValue(rrs []dns.RR) slog.Value {
+	attrs := make([]slog.Attr, 0, len(rrs))
+	for i, rr := range rrs {
+		h := rr.Header()
+		attrs = append(attrs, slog.Group(strconv.Itoa(i),
+			slog.String("name", h.Name),
+			slog.String("type", dns.Type(h.Rrtype).String()),
+			slog.Uint64("ttl", uint64(h.Ttl)),
+			slog.Bool("flush", h.Class&(1<<15) != 0),
+			slog.String("rdata", strings.TrimPrefix(rr.String(), h.String())),
+		))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/miekg/dns"
 )
 
-func (c *mdnsConn) Messages() <-chan *dns.Msg {
+func (c *mdnsConn) Messages() <-chan *ReceivedMsg {
 	return c.msgs
 }
 
@@ -16,9 +16,10 @@ func (c *mdnsConn) SendMsg(msg *dns.Msg) error {
 	defer logger.Debug("sent DNS message",
 		slog.Int("questions", len(msg.Question)),
 		slog.Int("answers", len(msg.Answer)),
-		slog.Any("names", msgNames(msg)))
+		slog.Any("names", msgNames(msg)),
+		c.msgAttr(msg))
 
-	b, err := msg.Pack()
+	b, err := packMulticast(msg)
 	if err != nil {
 		return err
 	}
@@ -30,7 +31,8 @@ func (c *mdnsConn) SendMsgTo(msg *dns.Msg, addr *net.UDPAddr) error {
 		slog.String("to", addr.String()),
 		slog.Int("questions", len(msg.Question)),
 		slog.Int("answers", len(msg.Answer)),
-		slog.Any("names", msgNames(msg)))
+		slog.Any("names", msgNames(msg)),
+		c.msgAttr(msg))
 
 	b, err := msg.Pack()
 	if err != nil {
@@ -40,22 +42,23 @@ func (c *mdnsConn) SendMsgTo(msg *dns.Msg, addr *net.UDPAddr) error {
 }
 
 func (c *mdnsConn) startRecvLoop(bufSize int) {
-	if c.conn4 != nil {
+	c.ifMu.Lock()
+	defer c.ifMu.Unlock()
+	c.serve = func(ic *ifaceConn) {
 		c.wg.Go(func() {
-			recvLoop(c.conn4, c.msgs, bufSize)
+			c.recvLoop(ic, bufSize)
 		})
 	}
-	if c.conn6 != nil {
-		c.wg.Go(func() {
-			recvLoop(c.conn6, c.msgs, bufSize)
-		})
+	for _, ic := range c.conns {
+		c.serve(ic)
 	}
 }
 
-func recvLoop(conn *net.UDPConn, msgCh chan<- *dns.Msg, bufSize int) {
+func (c *mdnsConn) recvLoop(ic *ifaceConn, bufSize int) {
+	network := ic.network()
 	buf := make([]byte, bufSize)
 	for {
-		n, from, err := conn.ReadFromUDP(buf)
+		n, ifIndex, from, dst, err := ic.read(buf)
 		if errors.Is(err, net.ErrClosed) {
 			return
 		}
@@ -63,10 +66,32 @@ func recvLoop(conn *net.UDPConn, msgCh chan<- *dns.Msg, bufSize int) {
 			logger.Warn("error receiving UDP message", slog.Any("error", err))
 			continue
 		}
+		// any packet shows the socket is alive, even one for another socket
+		ic.act.recv()
+		if !ic.owns(ifIndex, dst) {
+			continue
+		}
+		c.dump.dump("recv", network, c.ifaceName(ifIndex), from, buf[:n])
+
+		if !c.accepts(ifIndex) {
+			logger.Debug("ignoring packet from filtered interface", slog.String("interface", c.ifaceName(ifIndex)), slog.String("from", from.String()))
+			continue
+		}
+		c.broker.relay(from, buf[:n])
 
 		msg := new(dns.Msg)
 		if err := msg.Unpack(buf[:n]); err != nil {
-			logger.Warn("error unpacking DNS message", slog.Any("error", err))
+			logger.Warn("error unpacking DNS message",
+				slog.String("from", from.String()),
+				slog.String("interface", c.ifaceName(ifIndex)),
+				slog.Int("size", n),
+				slog.Any("error", err))
+			continue
+		}
+
+		if err := validateMsg(msg, from, c.compliance, &c.truncated); err != nil {
+			c.invalid.Add(1)
+			logger.Debug("ignoring invalid DNS message", slog.String("from", from.String()), slog.Any("reason", err))
 			continue
 		}
 
@@ -74,16 +99,43 @@ func recvLoop(conn *net.UDPConn, msgCh chan<- *dns.Msg, bufSize int) {
 			slog.String("from", from.String()),
 			slog.Int("questions", len(msg.Question)),
 			slog.Int("answers", len(msg.Answer)),
-			slog.Any("names", msgNames(msg)))
+			slog.Any("names", msgNames(msg)),
+			c.msgAttr(msg))
+
+		rm := &ReceivedMsg{Msg: msg, Src: from, IfIndex: ifIndex, Dst: dst}
+		if c.filter != nil && !c.filter(rm) {
+			continue
+		}
+
+		if c.observe != nil {
+			c.observe(rm)
+		}
 
 		select {
-		case msgCh <- msg:
+		case c.msgs <- rm:
 		default:
+			c.dropped.Add(1)
 			logger.Debug("dropping DNS message due to full channel")
+			if c.onDrop != nil {
+				c.onDrop(msg)
+			}
 		}
 	}
 }
 
+// packMulticast packs msg for multicast. RFC 6762 §18.1 requires the ID of
+// multicast queries and responses to be zero on transmission; it is cleared on
+// the wire only, so msg itself is left untouched. Unicast sends keep the ID,
+// which legacy unicast responses must echo.
+func packMulticast(msg *dns.Msg) ([]byte, error) {
+	b, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	b[0], b[1] = 0, 0
+	return b, nil
+}
+
 func msgNames(m *dns.Msg) []string {
 	names := make(map[string]struct{})
 	for _, q := range m.Question {
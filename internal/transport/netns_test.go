@@ -0,0 +1,104 @@
+//go:build linux
+
+package transport_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/oosawy/simplemdns/internal/netnstest"
+	"github.com/oosawy/simplemdns/internal/transport"
+)
+
+// newNamespaced creates a transport on the mDNS port inside ns, joined on its
+// veth only.
+func newNamespaced(t *testing.T, ns *netnstest.Namespace) (transport.Transport, *net.Interface) {
+	t.Helper()
+	iface, err := ns.Interface()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tr transport.Transport
+	err = ns.Do(func() (err error) {
+		tr, err = transport.New(transport.Options{
+			BindTo:         transport.BindMDNSPort,
+			JoinIfaces:     []net.Interface{*iface},
+			UDPRecvBufSize: 9000,
+			MsgsChBufSize:  16,
+			StallTimeout:   -1,
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr, iface
+}
+
+// receive returns the first message from tr that match accepts.
+func receive(t *testing.T, tr transport.Transport, match func(*transport.ReceivedMsg) bool) *transport.ReceivedMsg {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m := <-tr.Messages():
+			if match(m) {
+				return m
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a message")
+		}
+	}
+}
+
+func TestQueryAndResponseAcrossNamespaces(t *testing.T) {
+	pair := netnstest.NewPair(t)
+	a, ifaceA := newNamespaced(t, pair.A)
+	b, ifaceB := newNamespaced(t, pair.B)
+
+	query := new(dns.Msg)
+	query.SetQuestion("host.local.", dns.TypeA)
+	if err := a.SendMsg(query); err != nil {
+		t.Fatal(err)
+	}
+
+	peerA := pair.A.Addr4.Addr()
+	got := receive(t, b, func(m *transport.ReceivedMsg) bool {
+		return !m.Response && m.Src.AddrPort().Addr().Unmap() == peerA
+	})
+	if len(got.Question) != 1 || got.Question[0].Name != "host.local." {
+		t.Fatalf("query: got questions %v", got.Question)
+	}
+	if got.IfIndex != ifaceB.Index {
+		t.Errorf("query: received on interface %d, want %d", got.IfIndex, ifaceB.Index)
+	}
+
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Authoritative = true
+	resp.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeA, Class: dns.ClassINET | 1<<15, Ttl: 120},
+		A:   pair.B.Addr4.Addr().AsSlice(),
+	}}
+	if err := b.SendMsg(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	peerB := pair.B.Addr4.Addr()
+	got = receive(t, a, func(m *transport.ReceivedMsg) bool {
+		return m.Response && m.Src.AddrPort().Addr().Unmap() == peerB
+	})
+	if len(got.Answer) != 1 || got.Answer[0].(*dns.A).A.String() != peerB.String() {
+		t.Fatalf("response: got answers %v", got.Answer)
+	}
+	if got.IfIndex != ifaceA.Index {
+		t.Errorf("response: received on interface %d, want %d", got.IfIndex, ifaceA.Index)
+	}
+	if !got.Dst.Equal(net.IPv4(224, 0, 0, 251)) {
+		t.Errorf("response: destination %v, want the mDNS group", got.Dst)
+	}
+}
@@ -2,18 +2,62 @@ package transport
 
 import (
 	"errors"
+	"io"
 	"net"
+	"time"
+
+	"github.com/miekg/dns"
 )
 
 type Options struct {
-	IPVersion      IPVersion
+	IPVersion      IPVersion // zero to detect from the addresses of JoinIfaces
 	BindTo         BindStrategy
-	JoinIfaces     []net.Interface // nil or empty for all available multicast interfaces
-	UDPRecvBufSize int             // should be in the range 1500-9000; smaller values may cause data loss
-	MsgsChBufSize  int             // buffer size for the msgs channel; drops messages when full
+	LocalAddr      *net.UDPAddr            // if set, overrides BindTo and restricts the transport to this address's family
+	JoinIfaces     []net.Interface         // nil or empty for all available multicast interfaces
+	IncludeVirtual bool                    // with default JoinIfaces, also use virtual (container/VM/VPN) interfaces
+	AcceptIfaces   []int                   // if non-empty, only packets received on these interface indices are delivered
+	UDPRecvBufSize int                     // should be in the range 1500-9000; smaller values may cause data loss
+	MsgsChBufSize  int                     // buffer size for the msgs channel; drops messages when full
+	OnDrop         func(*dns.Msg)          // called when a message is dropped because msgs is full; must not block
+	Filter         func(*ReceivedMsg) bool // called with every valid received message before Observe; may modify it, or return false to drop it
+	Observe        func(*ReceivedMsg)      // called with every valid received message; must not block
+	WriteTimeout   time.Duration           // per-send write deadline; zero or negative disables it
+	StallTimeout   time.Duration           // report a stall if nothing is received this long after a send; zero or negative disables it
+	LogMessages    bool                    // log full message contents at Debug instead of just counts and names
+	PacketDump     io.Writer               // if set, hex dumps of every sent and received packet are written here
+	Broker         string                  // Unix socket path for sharing port 5353 between processes; empty disables broker mode
+	Compliance     Compliance              // how strictly received traffic is checked against RFC 6762
+	BestEffort     bool                    // keep a socket that joined no multicast group instead of failing
+	SharePort      bool                    // bind the port alongside other processes that allow reuse, such as avahi-daemon or mDNSResponder
+
+	// WatchInterfaces follows interfaces coming up, going down and changing
+	// addresses, updating the sockets and group memberships to match: the
+	// default interface set is rediscovered, while a given one (JoinIfaces,
+	// LocalAddr or SetInterfaces) is kept but refreshed. OnInterfacesChanged,
+	// if set, is called with the new set after each update; it must not block.
+	WatchInterfaces     bool
+	OnInterfacesChanged func([]net.Interface)
+
+	discovered bool // JoinIfaces was filled in by withDefaults
+	detected   bool // IPVersion was filled in by withDefaults
 }
 
 func (o Options) withDefaults() (Options, error) {
+	if o.LocalAddr != nil {
+		if o.LocalAddr.IP.To4() != nil {
+			o.IPVersion = IPv4
+		} else {
+			o.IPVersion = IPv6
+		}
+		if len(o.JoinIfaces) == 0 && !o.LocalAddr.IP.IsUnspecified() {
+			iface, err := interfaceByAddr(o.LocalAddr.IP)
+			if err != nil {
+				return Options{}, err
+			}
+			o.JoinIfaces = []net.Interface{*iface}
+		}
+	}
+
 	if len(o.JoinIfaces) == 0 {
 		ifaces, err := multicastInterfaces()
 		if err != nil {
@@ -22,7 +66,16 @@ func (o Options) withDefaults() (Options, error) {
 		if len(ifaces) == 0 {
 			return Options{}, errors.New("no multicast interfaces available")
 		}
+		if !o.IncludeVirtual {
+			ifaces = withoutVirtual(ifaces)
+		}
 		o.JoinIfaces = ifaces
+		o.discovered = true
+	}
+
+	if o.IPVersion == 0 {
+		o.IPVersion = detectIPVersion(o.JoinIfaces)
+		o.detected = true
 	}
 
 	return o, nil
@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// PortInUseError is returned when the mDNS port cannot be bound because
+// another process owns it, typically a system mDNS responder.
+type PortInUseError struct {
+	Port   int
+	Owners []PortOwner // best-effort; empty if the owner could not be determined
+	Err    error
+}
+
+// PortOwner is a process that appears to hold the port.
+type PortOwner struct {
+	PID  int // 0 if unknown
+	Name string
+}
+
+func (e *PortInUseError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "port %d is already in use", e.Port)
+	if len(e.Owners) > 0 {
+		b.WriteString(" by ")
+		for i, o := range e.Owners {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(o.Name)
+			if o.PID != 0 {
+				fmt.Fprintf(&b, " (pid %d)", o.PID)
+			}
+		}
+	}
+	b.WriteString("; bind an ephemeral port (BindZeroAddr) for query-only use, share the port with a daemon that allows reuse (SharePort), or with other simplemdns processes via Broker: ")
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *PortInUseError) Unwrap() error { return e.Err }
+
+// diagnoseBindError wraps err with the likely owner of port when the bind
+// failed because the port is taken.
+func diagnoseBindError(err error, port int) error {
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		return err
+	}
+	return &PortInUseError{Port: port, Owners: portOwners(port), Err: err}
+}
@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// portOwners finds the processes holding UDP port by matching socket inodes
+// from /proc/net/udp{,6} against /proc/*/fd. Processes of other users are
+// only visible with sufficient privileges.
+func portOwners(port int) []PortOwner {
+	inodes := make(map[string]struct{})
+	for _, f := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		udpInodes(f, port, inodes)
+	}
+	if len(inodes) == 0 {
+		return nil
+	}
+
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	seen := make(map[int]struct{})
+	var owners []PortOwner
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		if _, ok := inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")]; !ok {
+			continue
+		}
+
+		pid, _ := strconv.Atoi(strings.Split(fd, "/")[2])
+		if _, dup := seen[pid]; dup {
+			continue
+		}
+		seen[pid] = struct{}{}
+
+		comm, _ := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+		owners = append(owners, PortOwner{PID: pid, Name: strings.TrimSpace(string(comm))})
+	}
+
+	if len(owners) == 0 {
+		// The socket exists but belongs to a process we can't inspect.
+		owners = append(owners, PortOwner{Name: "another process (run as root to identify it)"})
+	}
+	return owners
+}
+
+// udpInodes adds the inodes of sockets bound to port listed in a
+// /proc/net/udp style file to inodes.
+func udpInodes(path string, port int, inodes map[string]struct{}) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	suffix := fmt.Sprintf(":%04X", port)
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 || !strings.HasSuffix(fields[1], suffix) {
+			continue
+		}
+		inodes[fields[9]] = struct{}{}
+	}
+}
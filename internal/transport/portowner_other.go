@@ -0,0 +1,20 @@
+//go:build !linux
+
+package transport
+
+import "runtime"
+
+// portOwners guesses the owner from the platform's well-known mDNS service,
+// since there is no portable way to map a port to a process.
+func portOwners(port int) []PortOwner {
+	if port != mdnsPort {
+		return nil
+	}
+	switch runtime.GOOS {
+	case "darwin", "ios":
+		return []PortOwner{{Name: "mDNSResponder"}}
+	case "windows":
+		return []PortOwner{{Name: "the Windows DNS Client service (Dnscache)"}}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd || windows)
+
+package transport
+
+import "syscall"
+
+// reuseControl is a no-op where address reuse is not wired up; only one
+// interface can then use a fixed port.
+func reuseControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
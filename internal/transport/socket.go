@@ -1,185 +1,413 @@
 package transport
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 )
 
+// socket holds one UDP socket per interface and family. Each joins the mDNS
+// group and sends multicasts on its own interface only, so sends need no
+// shared state and can run concurrently, and every received multicast is
+// attributed to the interface it arrived on. When the mDNS port is bound,
+// the sockets share it through SO_REUSEADDR/SO_REUSEPORT.
 type socket struct {
-	conn4    *net.UDPConn
-	conn6    *net.UDPConn
-	connIPv4 *ipv4.PacketConn
-	connIPv6 *ipv6.PacketConn
+	// ifMu guards the interface set and the sockets on it, which
+	// setInterfaces replaces as a whole (the slices are never modified once
+	// published), so senders work on a snapshot.
+	ifMu    sync.RWMutex
+	ifaces  []net.Interface
+	conns   []*ifaceConn
+	noGroup IPVersion // families without any group joined
+	closed  bool
+
+	// discovered tells that the interface set is the default one rather
+	// than given, so the interface watcher rediscovers it; guarded by ifMu.
+	discovered     bool
+	includeVirtual bool
+
+	addr4, addr6 *net.UDPAddr // bind addresses
+	// wanted are the families to run, and working those set up; the others
+	// are retried as interfaces change. working and failed are guarded by
+	// ifMu once the socket is set up.
+	wanted  IPVersion
+	working IPVersion
+
+	// serve starts receiving on a socket; setInterfaces calls it for the
+	// sockets it opens. Set by the owner before anything else can happen.
+	serve func(*ifaceConn)
+
+	failed     []*FamilyError // wanted families whose sockets could not be set up
+	bestEffort bool           // keep sockets that joined no multicast group
+	sharePort  bool           // bind the port even if another process holds it with reuse allowed
+
+	writeTimeout time.Duration
+	dump         *dumper
+
+	sendRetries  atomic.Uint64 // writes retried after a transient error
+	sendFailures atomic.Uint64 // writes that failed, after any retries
 
-	ifaces       []net.Interface
-	ifacesNoIPv4 map[int]struct{} // keyed by Interface.Index
-	ifacesNoIPv6 map[int]struct{} // keyed by Interface.Index
+	closeOnce sync.Once
+}
 
-	// Protect SetMulticastInterface + WriteToUDP as a single atomic operation
-	// to avoid races when multicast is called concurrently from multiple goroutines.
-	sendMu sync.Mutex
+// ifaceConn is the socket of one family on one interface.
+type ifaceConn struct {
+	iface  *net.Interface // nil for a family's fallback socket, which is on no interface
+	family IPVersion
+	conn   *net.UDPConn
+	pc4    *ipv4.PacketConn // set for IPv4
+	pc6    *ipv6.PacketConn // set for IPv6
+	joined bool             // the mDNS group is joined; guarded by socket.ifMu
+	act    activity
+}
 
-	closeOnce sync.Once
+func (ic *ifaceConn) network() string {
+	return networkOf(ic.family)
+}
+
+// ifaceName returns the name of the socket's interface, or an empty string
+// for a fallback socket.
+func (ic *ifaceConn) ifaceName() string {
+	if ic.iface == nil {
+		return ""
+	}
+	return ic.iface.Name
 }
 
 func newSocket(opts Options) (*socket, error) {
 	s := &socket{
 		ifaces:       opts.JoinIfaces,
-		ifacesNoIPv4: make(map[int]struct{}),
-		ifacesNoIPv6: make(map[int]struct{}),
+		writeTimeout: opts.WriteTimeout,
+		dump:         newDumper(opts.PacketDump),
+		bestEffort:   opts.BestEffort,
+		sharePort:    opts.SharePort,
+		serve:        func(*ifaceConn) {},
+
+		discovered:     opts.discovered,
+		includeVirtual: opts.IncludeVirtual,
 	}
 
-	addr4, addr6 := bindAddrs(opts.BindTo)
+	s.addr4, s.addr6 = bindAddrs(opts.BindTo)
+	if opts.LocalAddr != nil {
+		s.addr4, s.addr6 = opts.LocalAddr, opts.LocalAddr
+	}
+
+	// A family detected missing may show up later, e.g. when IPv6 addresses
+	// are configured after boot.
+	s.wanted = opts.IPVersion
+	if opts.detected {
+		s.wanted = IPv4And6
+	}
 
 	var err4, err6 error
 	if opts.IPVersion&IPv4 != 0 {
-		err4 = s.newUDP4Conn(addr4)
+		err4 = s.openFamily(IPv4)
 	}
 	if opts.IPVersion&IPv6 != 0 {
-		err6 = s.newUDP6Conn(addr6)
+		err6 = s.openFamily(IPv6)
 	}
 
-	if err4 != nil && err6 != nil {
-		logger.Debug("failed to create both IPv4 and IPv6 socket", "err4", err4, "err6", err6)
+	if s.working == 0 {
+		logger.Debug("failed to create any socket", "err4", err4, "err6", err6)
 		return nil, errors.Join(err4, err6)
 	}
 
 	if err4 != nil {
 		logger.Warn("failed to create IPv4 socket", "err", err4)
+		s.failed = append(s.failed, &FamilyError{Family: IPv4, Err: err4})
 	}
 	if err6 != nil {
 		logger.Warn("failed to create IPv6 socket", "err", err6)
+		s.failed = append(s.failed, &FamilyError{Family: IPv6, Err: err6})
 	}
 
-	logger.Debug("sockets created", slog.Bool("ipv4", s.conn4 != nil), slog.Bool("ipv6", s.conn6 != nil))
+	logger.Debug("sockets created", slog.Bool("ipv4", s.working&IPv4 != 0), slog.Bool("ipv6", s.working&IPv6 != 0), slog.Int("sockets", len(s.conns)))
 
 	return s, nil
 }
 
-func (s *socket) close() error {
-	var err4, err6 error
-	s.closeOnce.Do(func() {
-		if s.conn4 != nil {
-			// closing conn4 is sufficient to close connIPv4
-			err4 = s.conn4.Close()
-		}
-		if s.conn6 != nil {
-			// closing conn6 is sufficient to close connIPv6
-			err6 = s.conn6.Close()
-		}
-	})
-	return errors.Join(err4, err6)
-}
-
-func (s *socket) newUDP4Conn(addr *net.UDPAddr) error {
-	conn, err := net.ListenUDP("udp4", addr)
+// checkPort makes sure the sockets of family will not share the mDNS port
+// with another process. The sockets share the port with each other, but must
+// not share it with, e.g., a system daemon that also allows reuse, unless
+// asked to. A socket bound without reuse finds out.
+func (s *socket) checkPort(family IPVersion) error {
+	addr := s.bindAddr(family)
+	if addr.Port == 0 || s.sharePort {
+		return nil
+	}
+	probe, err := net.ListenUDP(networkOf(family), addr)
 	if err != nil {
-		return err
+		return diagnoseBindError(err, addr.Port)
 	}
+	return probe.Close()
+}
 
-	v4conn := ipv4.NewPacketConn(conn)
-	s.connIPv4 = v4conn
-	if err := v4conn.SetMulticastTTL(_MDNSDefaultHopLimit); err != nil {
-		logger.Debug("failed to set multicast TTL on IPv4 socket; continuing", slog.Any("error", err))
-	}
-	if err := v4conn.SetMulticastLoopback(true); err != nil {
-		logger.Debug("failed to set multicast loopback on IPv4 socket; continuing", slog.Any("error", err))
-	}
-	if err := v4conn.SetControlMessage(ipv4.FlagDst|ipv4.FlagInterface, true); err != nil {
-		logger.Debug("failed to set control message on IPv4 socket; continuing", slog.Any("error", err))
+// openFamily opens the sockets of family on the interfaces that have an
+// address of the family.
+func (s *socket) openFamily(family IPVersion) error {
+	if err := s.checkPort(family); err != nil {
+		return err
 	}
+	addr := s.bindAddr(family)
 
+	var conns []*ifaceConn
 	var joined int
-
-	for _, iface := range s.ifaces {
-		supports, _ := interfaceSupports(&iface, IPv4)
-		if !supports {
-			s.ifacesNoIPv4[iface.Index] = struct{}{}
+	var lastErr error
+	for i := range s.ifaces {
+		iface := &s.ifaces[i]
+		if supports, _ := interfaceSupports(iface, family); !supports {
 			continue
 		}
-
-		if err := v4conn.JoinGroup(&iface, mdnsGaddrUDP4); err != nil {
-			logger.Debug("failed to join ipv4 multicast group; skipping", slog.String("interface", iface.Name), slog.Any("error", err))
-		} else {
+		ic, err := s.openConn(family, iface)
+		if err != nil {
+			logger.Debug("failed to open socket; skipping interface", slog.String("family", family.String()), slog.String("interface", iface.Name), slog.Any("error", err))
+			lastErr = err
+			continue
+		}
+		conns = append(conns, ic)
+		if ic.joined {
 			joined++
 		}
 	}
 
 	if joined == 0 {
-		return errors.New("no multicast group joined on any interface for IPv4")
+		if !s.bestEffort {
+			closeConns(conns)
+			if lastErr != nil {
+				return diagnoseBindError(lastErr, addr.Port)
+			}
+			return errors.New("no multicast group joined on any interface for " + family.String())
+		}
+		logger.Warn("no multicast group joined on any interface for "+family.String()+"; mDNS may not work", slog.Int("total", len(s.ifaces)))
+		s.noGroup |= family
+		if len(conns) == 0 {
+			ic, err := s.openConn(family, nil)
+			if err != nil {
+				return diagnoseBindError(err, addr.Port)
+			}
+			conns = append(conns, ic)
+		}
 	} else {
-		logger.Debug("joined multicast group on IPv4 interfaces", slog.Int("joined", joined), slog.Int("total", len(s.ifaces)))
+		logger.Debug("joined multicast group on "+family.String()+" interfaces", slog.Int("joined", joined), slog.Int("total", len(s.ifaces)))
 	}
 
-	s.conn4 = conn
+	s.conns = append(s.conns, conns...)
+	s.working |= family
 	return nil
 }
 
-func (s *socket) newUDP6Conn(addr *net.UDPAddr) error {
-	conn, err := net.ListenUDP("udp6", addr)
+func (s *socket) bindAddr(family IPVersion) *net.UDPAddr {
+	if family == IPv4 {
+		return s.addr4
+	}
+	return s.addr6
+}
+
+func networkOf(family IPVersion) string {
+	if family == IPv4 {
+		return "udp4"
+	}
+	return "udp6"
+}
+
+// openConn opens a socket of family that multicasts on iface and joins the
+// mDNS group there. A failed join is logged and leaves the socket usable
+// for sending. With a nil iface the socket is on no particular interface and
+// joins nothing.
+func (s *socket) openConn(family IPVersion, iface *net.Interface) (*ifaceConn, error) {
+	addr := s.bindAddr(family)
+	lc := net.ListenConfig{}
+	if addr.Port != 0 {
+		lc.Control = reuseControl
+	}
+	pc, err := lc.ListenPacket(context.Background(), networkOf(family), addr.String())
+	if err != nil {
+		return nil, err
+	}
+	ic := &ifaceConn{iface: iface, family: family, conn: pc.(*net.UDPConn)}
+	// looped back multicasts are only delivered to sockets on the mDNS port
+	ic.act.loopback = addr.Port == mdnsPort
+
+	if family == IPv4 {
+		err = s.setup4(ic)
+	} else {
+		err = s.setup6(ic)
+	}
 	if err != nil {
+		ic.conn.Close()
+		return nil, err
+	}
+	return ic, nil
+}
+
+func (s *socket) setup4(ic *ifaceConn) error {
+	pc := ipv4.NewPacketConn(ic.conn)
+	ic.pc4 = pc
+	if err := pc.SetMulticastTTL(_MDNSDefaultHopLimit); err != nil {
+		logger.Debug("failed to set multicast TTL on IPv4 socket; continuing", slog.Any("error", err))
+	}
+	if err := pc.SetMulticastLoopback(true); err != nil {
+		logger.Debug("failed to set multicast loopback on IPv4 socket; continuing", slog.Any("error", err))
+	}
+	if err := pc.SetControlMessage(ipv4.FlagDst|ipv4.FlagInterface, true); err != nil {
+		logger.Debug("failed to set control message on IPv4 socket; continuing", slog.Any("error", err))
+	}
+	if ic.iface == nil {
+		return nil
+	}
+
+	if err := pc.SetMulticastInterface(ic.iface); err != nil {
 		return err
 	}
+	ic.join()
+	return nil
+}
 
-	v6conn := ipv6.NewPacketConn(conn)
-	s.connIPv6 = v6conn
-	if err := v6conn.SetMulticastHopLimit(_MDNSDefaultHopLimit); err != nil {
+func (s *socket) setup6(ic *ifaceConn) error {
+	pc := ipv6.NewPacketConn(ic.conn)
+	ic.pc6 = pc
+	if err := pc.SetMulticastHopLimit(_MDNSDefaultHopLimit); err != nil {
 		logger.Debug("failed to set multicast hop limit on IPv6 socket; continuing", slog.Any("error", err))
 	}
-	if err := v6conn.SetMulticastLoopback(true); err != nil {
+	if err := pc.SetMulticastLoopback(true); err != nil {
 		logger.Debug("failed to set multicast loopback on IPv6 socket; continuing", slog.Any("error", err))
 	}
-	if err := v6conn.SetControlMessage(ipv6.FlagDst|ipv6.FlagInterface, true); err != nil {
+	if err := pc.SetControlMessage(ipv6.FlagDst|ipv6.FlagInterface, true); err != nil {
 		logger.Debug("failed to set control message on IPv6 socket; continuing", slog.Any("error", err))
 	}
+	if ic.iface == nil {
+		return nil
+	}
 
-	var joined int
+	if err := pc.SetMulticastInterface(ic.iface); err != nil {
+		return err
+	}
+	ic.join()
+	return nil
+}
+
+// join joins the mDNS group on the socket's interface, unless already done.
+func (ic *ifaceConn) join() {
+	if ic.joined || ic.iface == nil {
+		return
+	}
+	var err error
+	if ic.family == IPv4 {
+		err = ic.pc4.JoinGroup(ic.iface, mdnsGaddrUDP4)
+	} else {
+		err = ic.pc6.JoinGroup(ic.iface, mdnsGaddrUDP6)
+	}
+	if err != nil {
+		logger.Debug("failed to join multicast group; skipping", slog.String("family", ic.family.String()), slog.String("interface", ic.iface.Name), slog.Any("error", err))
+		return
+	}
+	ic.joined = true
+}
+
+func closeConns(conns []*ifaceConn) {
+	for _, ic := range conns {
+		ic.conn.Close()
+	}
+}
+
+// families returns the IP versions the socket has connections for.
+func (s *socket) families() IPVersion {
+	s.ifMu.RLock()
+	defer s.ifMu.RUnlock()
+	return s.working
+}
+
+// failures returns the wanted families that could not be set up.
+func (s *socket) failures() []*FamilyError {
+	s.ifMu.RLock()
+	defer s.ifMu.RUnlock()
+	return s.failed
+}
 
-	for _, iface := range s.ifaces {
-		supports, _ := interfaceSupports(&iface, IPv6)
-		if !supports {
-			s.ifacesNoIPv6[iface.Index] = struct{}{}
+// connections returns the current sockets. The returned slice must not be
+// modified.
+func (s *socket) connections() []*ifaceConn {
+	s.ifMu.RLock()
+	defer s.ifMu.RUnlock()
+	return s.conns
+}
+
+// syscallConns returns the raw connections of the sockets of family, which
+// must be IPv4 or IPv6, one per interface.
+func (s *socket) syscallConns(family IPVersion) ([]syscall.RawConn, error) {
+	if family != IPv4 && family != IPv6 {
+		return nil, errors.New("family must be IPv4 or IPv6")
+	}
+	var rcs []syscall.RawConn
+	for _, ic := range s.connections() {
+		if ic.family != family {
 			continue
 		}
-
-		if err := v6conn.JoinGroup(&iface, mdnsGaddrUDP6); err != nil {
-			logger.Debug("failed to join ipv6 multicast group; skipping", slog.String("interface", iface.Name), slog.Any("error", err))
-		} else {
-			joined++
+		rc, err := ic.conn.SyscallConn()
+		if err != nil {
+			return nil, err
 		}
+		rcs = append(rcs, rc)
 	}
-
-	if joined == 0 {
-		return errors.New("no multicast group joined on any interface for IPv6")
-	} else {
-		logger.Debug("joined multicast group on IPv6 interfaces", slog.Int("joined", joined), slog.Int("total", len(s.ifaces)))
+	if len(rcs) == 0 {
+		return nil, errors.New("no " + family.String() + " socket available")
 	}
+	return rcs, nil
+}
 
-	s.conn6 = conn
-	return nil
+// groupless returns the families whose sockets have no multicast group joined.
+func (s *socket) groupless() IPVersion {
+	s.ifMu.RLock()
+	defer s.ifMu.RUnlock()
+	return s.noGroup
+}
+
+func (s *socket) close() error {
+	var errs []error
+	s.closeOnce.Do(func() {
+		s.ifMu.Lock()
+		defer s.ifMu.Unlock()
+		s.closed = true
+		for _, ic := range s.conns {
+			// closing conn is sufficient to close the packet conn
+			errs = append(errs, ic.conn.Close())
+		}
+	})
+	return errors.Join(errs...)
 }
 
 func (s *socket) unicast(b []byte, addr *net.UDPAddr) error {
-	var err error
+	var family IPVersion
 	if addr.IP.To4() != nil {
-		if s.conn4 == nil {
-			return errors.New("no IPv4 socket available")
-		}
-		_, err = s.conn4.WriteToUDP(b, addr)
+		family = IPv4
 	} else if addr.IP.To16() != nil {
-		if s.conn6 == nil {
-			return errors.New("no IPv6 socket available")
-		}
-		_, err = s.conn6.WriteToUDP(b, addr)
+		family = IPv6
 	} else {
 		return errors.New("address is not valid IPv4 or IPv6")
 	}
 
+	ic := s.unicastConn(family, addr.Zone)
+	if ic == nil {
+		return errors.New("no " + family.String() + " socket available")
+	}
+	err := s.writeTo(ic.conn, b, addr)
+	if err == nil {
+		s.dump.dump("send", ic.network(), ic.ifaceName(), addr, b)
+	}
+
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		logger.Warn("timed out writing to unicast address", slog.String("address", addr.String()))
+		return err
+	}
 	if err != nil {
 		logger.Debug("failed to write to unicast address", slog.String("address", addr.String()), slog.Any("error", err))
 		return err
@@ -189,47 +417,147 @@ func (s *socket) unicast(b []byte, addr *net.UDPAddr) error {
 	return nil
 }
 
-func (s *socket) multicast(b []byte) error {
-	var sent4, sent6 int
+// unicastConn picks the socket of family to send a unicast from: the one on
+// the interface named by zone if there is one, else any. The kernel routes
+// the packet either way.
+func (s *socket) unicastConn(family IPVersion, zone string) *ifaceConn {
+	var any *ifaceConn
+	for _, ic := range s.connections() {
+		if ic.family != family {
+			continue
+		}
+		if zone != "" && ic.ifaceName() == zone {
+			return ic
+		}
+		if any == nil {
+			any = ic
+		}
+	}
+	return any
+}
 
-	if s.conn4 != nil {
-		for _, iface := range s.ifaces {
-			if _, no := s.ifacesNoIPv4[iface.Index]; no {
-				continue
-			}
-			s.sendMu.Lock()
-			if err := s.connIPv4.SetMulticastInterface(&iface); err != nil {
-				s.sendMu.Unlock()
-				logger.Debug("failed to set multicast interface on IPv4 socket; skipping", slog.String("interface", iface.Name), slog.Any("error", err))
-				continue
-			}
-			_, err := s.conn4.WriteToUDP(b, mdnsGaddrUDP4)
-			s.sendMu.Unlock()
-			if err != nil {
-				logger.Debug("failed to write to IPv4 multicast address; skipping", slog.String("interface", iface.Name), slog.Any("error", err))
-				continue
-			}
-			sent4++
+// read reads one packet, reporting the receiving interface index (0 if
+// unknown), the sender, and the destination address (nil if unknown).
+func (ic *ifaceConn) read(b []byte) (n, ifIndex int, src *net.UDPAddr, dst net.IP, err error) {
+	var addr net.Addr
+	if ic.pc4 != nil {
+		var cm *ipv4.ControlMessage
+		n, cm, addr, err = ic.pc4.ReadFrom(b)
+		if cm != nil {
+			ifIndex, dst = cm.IfIndex, cm.Dst
+		}
+	} else {
+		var cm *ipv6.ControlMessage
+		n, cm, addr, err = ic.pc6.ReadFrom(b)
+		if cm != nil {
+			ifIndex, dst = cm.IfIndex, cm.Dst
 		}
 	}
+	src, _ = addr.(*net.UDPAddr)
+	return n, ifIndex, src, dst, err
+}
 
-	if s.conn6 != nil {
-		for _, iface := range s.ifaces {
-			if _, no := s.ifacesNoIPv6[iface.Index]; no {
-				continue
-			}
-			s.sendMu.Lock()
-			if err := s.connIPv6.SetMulticastInterface(&iface); err != nil {
-				s.sendMu.Unlock()
-				logger.Debug("failed to set multicast interface on IPv6 socket; skipping", slog.String("interface", iface.Name), slog.Any("error", err))
-				continue
-			}
-			_, err := s.conn6.WriteToUDP(b, mdnsGaddrUDP6)
-			s.sendMu.Unlock()
+// owns reports whether a packet received by ic on interface ifIndex, sent to
+// dst, is ic's to deliver. Sockets sharing the mDNS port may each get a copy
+// of every multicast, whichever interface it arrived on; only the socket of
+// that interface keeps it. Unicasts go to one of the sockets and are kept by
+// whichever gets them, as are packets whose interface is unknown.
+func (ic *ifaceConn) owns(ifIndex int, dst net.IP) bool {
+	if ic.iface == nil || ifIndex == 0 || !dst.IsMulticast() {
+		return true
+	}
+	return ifIndex == ic.iface.Index
+}
+
+// ifaceName returns the name of the interface with the given index, or an
+// empty string if it is unknown.
+func (s *socket) ifaceName(index int) string {
+	if index == 0 {
+		return ""
+	}
+	for _, iface := range s.interfaces() {
+		if iface.Index == index {
+			return iface.Name
+		}
+	}
+	if iface, err := net.InterfaceByIndex(index); err == nil {
+		return iface.Name
+	}
+	return ""
+}
+
+// Send retry policy for transient write errors: up to sendRetries further
+// attempts, doubling the pause from sendRetryBackoff each time. The total is
+// kept well below typical write timeouts.
+const (
+	sendRetries      = 3
+	sendRetryBackoff = 2 * time.Millisecond
+)
+
+// writeTo writes b to addr on conn, bounded by the socket's write timeout so a
+// wedged interface cannot stall the send path indefinitely. Transient errors
+// are retried a few times with backoff before giving up.
+func (s *socket) writeTo(conn *net.UDPConn, b []byte, addr *net.UDPAddr) error {
+	backoff := sendRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := s.writeOnce(conn, b, addr)
+		if err == nil || attempt == sendRetries || !isTransientSendError(err) {
 			if err != nil {
-				logger.Debug("failed to write to IPv6 multicast address; skipping", slog.String("interface", iface.Name), slog.Any("error", err))
-				continue
+				s.sendFailures.Add(1)
 			}
+			return err
+		}
+		s.sendRetries.Add(1)
+		logger.Debug("transient write error; retrying", slog.String("address", addr.String()), slog.Duration("backoff", backoff), slog.Any("error", err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *socket) writeOnce(conn *net.UDPConn, b []byte, addr *net.UDPAddr) error {
+	if s.writeTimeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(s.writeTimeout)); err != nil {
+			return err
+		}
+	}
+	_, err := conn.WriteToUDP(b, addr)
+	return err
+}
+
+// isTransientSendError reports whether a failed write is worth retrying: the
+// kernel ran short of buffers, or the interface went down for a moment (e.g.
+// while Wi-Fi roams).
+func isTransientSendError(err error) bool {
+	return errors.Is(err, syscall.ENOBUFS) ||
+		errors.Is(err, syscall.ENOMEM) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.ENETDOWN)
+}
+
+// multicast sends b to the mDNS group on every interface, through each
+// interface's own socket. A family's fallback socket sends on the interface
+// the system picks for multicast.
+func (s *socket) multicast(b []byte) error {
+	var sent4, sent6 int
+	for _, ic := range s.connections() {
+		group := mdnsGaddrUDP4
+		if ic.family == IPv6 {
+			group = mdnsGaddrUDP6
+		}
+		err := s.writeTo(ic.conn, b, group)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			logger.Warn("timed out writing to "+ic.family.String()+" multicast address; skipping", slog.String("interface", ic.ifaceName()))
+			continue
+		}
+		if err != nil {
+			logger.Debug("failed to write to "+ic.family.String()+" multicast address; skipping", slog.String("interface", ic.ifaceName()), slog.Any("error", err))
+			continue
+		}
+		s.dump.dump("send", ic.network(), ic.ifaceName(), group, b)
+		ic.act.sent()
+		if ic.family == IPv4 {
+			sent4++
+		} else {
 			sent6++
 		}
 	}
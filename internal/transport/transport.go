@@ -3,21 +3,64 @@ package transport
 import (
 	"log/slog"
 	"net"
+	"syscall"
 
 	"github.com/miekg/dns"
 )
 
-// TODO: replace this with a more flexible logging solution
-var logger = slog.Default().With("lib", "simplemdns")
+// ReceivedMsg is a received DNS message along with where it came from and
+// where it arrived.
+type ReceivedMsg struct {
+	*dns.Msg
+	Src     *net.UDPAddr // sender
+	IfIndex int          // index of the receiving interface; 0 if unknown, as through a broker
+	Dst     net.IP       // destination address, the multicast group or a unicast address of ours; nil if unknown
+}
 
 // Transport is a minimal interface for mDNS transport.
 type Transport interface {
-	Messages() <-chan *dns.Msg
+	Messages() <-chan *ReceivedMsg
+	Errors() <-chan error // asynchronous runtime errors such as *StallError
 	SendMsg(*dns.Msg) error
 	SendMsgTo(*dns.Msg, *net.UDPAddr) error
+	SetInterfaces([]net.Interface) error
+	SyscallConns(IPVersion) ([]syscall.RawConn, error)
+	Stats() Stats
+	Status() Status
 	Close() error
 }
 
+// Status describes how the transport was set up.
+type Status struct {
+	BindTo    BindStrategy   // the strategy actually in effect; never BindAuto
+	IPVersion IPVersion      // the families with a working socket; 0 if unknown (brokered)
+	Failed    []*FamilyError // requested families that could not be set up
+	NoGroup   IPVersion      // families running without any multicast group joined (BestEffort)
+}
+
+// FamilyError reports that the socket for one IP family could not be set up
+// while the other family works.
+type FamilyError struct {
+	Family IPVersion
+	Err    error
+}
+
+func (e *FamilyError) Error() string {
+	return e.Family.String() + " unavailable: " + e.Err.Error()
+}
+
+func (e *FamilyError) Unwrap() error {
+	return e.Err
+}
+
+// Stats is a snapshot of transport counters.
+type Stats struct {
+	MsgsDropped  uint64 // received messages dropped because the msgs channel was full
+	MsgsInvalid  uint64 // received messages dropped for violating mDNS message rules
+	SendRetries  uint64 // writes retried after a transient error such as ENOBUFS
+	SendFailures uint64 // per-interface writes that failed, after any retries
+}
+
 // New creates a transport with given options. Minimal placeholder; implementation
 // will live in conn.go.
 func New(opts Options) (Transport, error) {
@@ -25,6 +68,27 @@ func New(opts Options) (Transport, error) {
 	if err != nil {
 		return nil, err
 	}
+	warnIfContainer()
 
+	if o.Broker != "" {
+		return newBrokered(o)
+	}
+	if o.BindTo == BindAuto {
+		return newAutoConn(o)
+	}
 	return newConn(o)
 }
+
+// newAutoConn binds the mDNS port for full send/receive capability, and falls
+// back to an ephemeral port (enough for queries) if that fails.
+func newAutoConn(opts Options) (Transport, error) {
+	opts.BindTo = BindMDNSPort
+	t, err := newConn(opts)
+	if err == nil {
+		return t, nil
+	}
+
+	logger.Info("could not bind mDNS port; falling back to an ephemeral port (query-only)", slog.Any("error", err))
+	opts.BindTo = BindZeroAddr
+	return newConn(opts)
+}
@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// continuationWindow is how long after a truncated query its sender's
+// known-answer list may continue in further packets. Responders wait 400-500ms
+// for them (RFC 6762 §7.2); the rest is slack for queriers that pace them.
+const continuationWindow = time.Second
+
+var (
+	errBadOpcode      = errors.New("opcode is not QUERY")
+	errBadRcode       = errors.New("non-zero rcode")
+	errBadSourcePort  = errors.New("response not sent from port 5353")
+	errEmptyQuery     = errors.New("query without questions")
+	errQueryHasAnswer = errors.New("query carries answers but no questions and follows no truncated query")
+)
+
+// validateMsg checks a received message against the mDNS message rules and
+// returns why it must be dropped, or nil. The opcode and rcode rules are
+// enforced in every mode since RFC 6762 requires such messages to be silently
+// ignored; the others only in Strict mode, where tc tracks the truncated
+// queries that known-answer continuations must follow.
+func validateMsg(m *dns.Msg, from *net.UDPAddr, compliance Compliance, tc *truncatedQueries) error {
+	// RFC 6762 §18.3
+	if m.Opcode != dns.OpcodeQuery {
+		return errBadOpcode
+	}
+	// RFC 6762 §18.11
+	if m.Rcode != dns.RcodeSuccess {
+		return errBadRcode
+	}
+
+	if compliance != Strict {
+		return nil
+	}
+
+	if m.Response {
+		// RFC 6762 §11; some devices answer from an ephemeral port anyway.
+		if from != nil && from.Port != mdnsPort {
+			return errBadSourcePort
+		}
+		return nil
+	}
+
+	now := time.Now()
+	if len(m.Question) == 0 {
+		// Known answers (and probe authority records) only make sense
+		// alongside the questions they qualify, or continuing the known-answer
+		// list of a truncated query (RFC 6762 §7.2).
+		if len(m.Answer) == 0 {
+			return errEmptyQuery
+		}
+		if !tc.follows(from, now) {
+			return errQueryHasAnswer
+		}
+	}
+	if m.Truncated {
+		tc.add(from, now)
+	}
+	return nil
+}
+
+// truncatedQueries remembers when each querier last sent a truncated query.
+// The zero value is ready to use.
+type truncatedQueries struct {
+	mu   sync.Mutex
+	last map[netip.Addr]time.Time
+}
+
+func (t *truncatedQueries) add(from *net.UDPAddr, now time.Time) {
+	if from == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last == nil {
+		t.last = make(map[netip.Addr]time.Time)
+	}
+	for addr, at := range t.last {
+		if now.Sub(at) > continuationWindow {
+			delete(t.last, addr)
+		}
+	}
+	t.last[from.AddrPort().Addr()] = now
+}
+
+// follows reports whether from sent a truncated query recently enough for a
+// message from it to continue that query's known-answer list.
+func (t *truncatedQueries) follows(from *net.UDPAddr, now time.Time) bool {
+	if from == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	at, ok := t.last[from.AddrPort().Addr()]
+	return ok && now.Sub(at) <= continuationWindow
+}
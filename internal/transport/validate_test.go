@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestValidateMsgKnownAnswerContinuation(t *testing.T) {
+	querier := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 10), Port: mdnsPort}
+	other := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 11), Port: mdnsPort}
+	known := &dns.PTR{Hdr: dns.RR_Header{Name: "_http._tcp.local.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 4500}, Ptr: "Web._http._tcp.local."}
+
+	continuation := new(dns.Msg)
+	continuation.Answer = []dns.RR{known}
+
+	var tc truncatedQueries
+	if err := validateMsg(continuation, querier, Strict, &tc); err != errQueryHasAnswer {
+		t.Fatalf("continuation without a truncated query: got %v, want %v", err, errQueryHasAnswer)
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("_http._tcp.local.", dns.TypePTR)
+	query.Id = 0
+	query.Truncated = true
+	query.Answer = []dns.RR{known}
+	if err := validateMsg(query, querier, Strict, &tc); err != nil {
+		t.Fatalf("truncated query: %v", err)
+	}
+
+	if err := validateMsg(continuation, querier, Strict, &tc); err != nil {
+		t.Errorf("continuation after a truncated query: %v", err)
+	}
+	if err := validateMsg(continuation, other, Strict, &tc); err != errQueryHasAnswer {
+		t.Errorf("continuation from another querier: got %v, want %v", err, errQueryHasAnswer)
+	}
+	if tc.follows(querier, time.Now().Add(2*continuationWindow)) {
+		t.Error("continuation accepted after the window closed")
+	}
+
+	if err := validateMsg(new(dns.Msg), querier, Strict, &tc); err != errEmptyQuery {
+		t.Errorf("empty query: got %v, want %v", err, errEmptyQuery)
+	}
+	if err := validateMsg(continuation, other, Lenient, &tc); err != nil {
+		t.Errorf("lenient continuation: %v", err)
+	}
+}
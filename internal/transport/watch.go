@@ -0,0 +1,135 @@
+package transport
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// interfaceSettle is how long the watcher waits after a change for the
+	// rest of it: bringing up an interface takes several link and address
+	// events, and IPv6 addresses appear a while after the link.
+	interfaceSettle = 500 * time.Millisecond
+	// interfacePoll is how often interfaces are checked where the system
+	// does not notify changes.
+	interfacePoll = 5 * time.Second
+)
+
+// startInterfaceWatch follows changes of the interfaces and updates the
+// sockets to match, calling onChange, if not nil, with the new set.
+func (c *mdnsConn) startInterfaceWatch(onChange func([]net.Interface)) {
+	events, err := interfaceEvents(c.done)
+	if err != nil {
+		logger.Debug("interface change notifications unavailable; polling", slog.Any("error", err))
+		ticker := time.NewTicker(interfacePoll)
+		ch := make(chan struct{}, 1)
+		c.wg.Go(func() {
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					select {
+					case ch <- struct{}{}:
+					default:
+					}
+				case <-c.done:
+					return
+				}
+			}
+		})
+		events = ch
+	}
+
+	// taken now, so that changes right after NewClient are not missed
+	last := interfacesFingerprint(c.interfaces())
+	c.wg.Go(func() {
+		for {
+			select {
+			case <-events:
+			case <-c.done:
+				return
+			}
+			select {
+			case <-time.After(interfaceSettle):
+			case <-c.done:
+				return
+			}
+			// events meanwhile are covered by what is read next
+			select {
+			case <-events:
+			default:
+			}
+
+			ifaces, ok := c.wantedInterfaces()
+			if !ok {
+				continue
+			}
+			fp := interfacesFingerprint(ifaces)
+			if fp == last {
+				continue
+			}
+			last = fp
+			if err := c.setInterfaces(ifaces); err != nil {
+				logger.Warn("failed to update interfaces", slog.Any("error", err))
+			}
+			if onChange != nil {
+				onChange(ifaces)
+			}
+		}
+	})
+}
+
+// wantedInterfaces returns the interfaces the sockets should be on now: the
+// usable multicast interfaces if the set is discovered, or the current state
+// of the given ones that are up. ok is false if there are none.
+func (c *mdnsConn) wantedInterfaces() (ifaces []net.Interface, ok bool) {
+	c.ifMu.RLock()
+	discovered, current := c.discovered, c.ifaces
+	c.ifMu.RUnlock()
+
+	if discovered {
+		all, err := multicastInterfaces()
+		if err != nil {
+			logger.Debug("failed to list interfaces", slog.Any("error", err))
+			return nil, false
+		}
+		ifaces = all
+		if !c.includeVirtual {
+			ifaces = withoutVirtual(all)
+		}
+	} else {
+		for _, old := range current {
+			iface, err := net.InterfaceByIndex(old.Index)
+			if err != nil || iface.Flags&net.FlagUp == 0 {
+				continue
+			}
+			ifaces = append(ifaces, *iface)
+		}
+	}
+	if len(ifaces) == 0 {
+		logger.Warn("no usable interfaces left; keeping the previous ones until one comes back")
+		return nil, false
+	}
+	return ifaces, true
+}
+
+// interfacesFingerprint describes what matters of ifaces to the sockets:
+// which interfaces, whether they are up, and their addresses.
+func interfacesFingerprint(ifaces []net.Interface) string {
+	var b strings.Builder
+	for _, iface := range ifaces {
+		fmt.Fprintf(&b, "%d %s %v", iface.Index, iface.Name, iface.Flags)
+		addrs, _ := iface.Addrs()
+		strs := make([]string, 0, len(addrs))
+		for _, a := range addrs {
+			strs = append(strs, a.String())
+		}
+		slices.Sort(strs)
+		fmt.Fprintf(&b, " %v;", strs)
+	}
+	return b.String()
+}
@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// interfaceEvents subscribes to the kernel's link and address notifications
+// over rtnetlink. The returned channel receives a value after each batch of
+// them, until done is closed.
+func interfaceEvents(done <-chan struct{}) (<-chan struct{}, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	sa := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR,
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	// through os.File, reads go through the runtime poller and Close
+	// unblocks them
+	f := os.NewFile(uintptr(fd), "rtnetlink")
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		<-done
+		f.Close()
+	}()
+	go func() {
+		buf := make([]byte, os.Getpagesize())
+		for {
+			if _, err := f.Read(buf); err != nil && !errors.Is(err, unix.ENOBUFS) {
+				// ENOBUFS just means notifications were lost, and a check is
+				// due all the same
+				if !errors.Is(err, os.ErrClosed) {
+					logger.Warn("stopped reading interface notifications", slog.Any("error", err))
+				}
+				return
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
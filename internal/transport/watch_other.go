@@ -0,0 +1,10 @@
+//go:build !linux
+
+package transport
+
+import "errors"
+
+// interfaceEvents is not implemented here; the watcher polls instead.
+func interfaceEvents(done <-chan struct{}) (<-chan struct{}, error) {
+	return nil, errors.New("not supported on this platform")
+}
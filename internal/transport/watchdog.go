@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// StallError reports that a socket stopped receiving: we multicast a message
+// (which loops back to us) but nothing has been read since. This typically
+// means the socket died underneath us, e.g. after suspend/resume or a driver
+// reset.
+type StallError struct {
+	Network   string        // "udp4" or "udp6"
+	Interface string        // the interface of the socket
+	Since     time.Duration // time since the first unanswered send
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("%s receive loop on %s stalled: nothing received %s after sending", e.Network, e.Interface, e.Since.Round(time.Millisecond))
+}
+
+// activity tracks the sends and receives of one socket for the watchdog.
+type activity struct {
+	unanswered atomic.Int64 // unix nanos of the first multicast send with nothing read since; 0 if none
+	stalled    atomic.Bool  // whether the current stall has been reported
+	loopback   bool         // multicast loopback reaches the socket, so sends echo back to it
+}
+
+// sent records a multicast send. Only the first send after a read counts, so
+// that retransmissions do not keep pushing a stall out of sight.
+func (a *activity) sent() { a.unanswered.CompareAndSwap(0, time.Now().UnixNano()) }
+func (a *activity) recv() { a.unanswered.Store(0) }
+
+func (c *mdnsConn) startWatchdog(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	c.wg.Go(func() {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case now := <-ticker.C:
+				for _, ic := range c.watchedConns() {
+					c.checkStall(now, ic, timeout)
+				}
+			}
+		}
+	})
+}
+
+// watchedConns returns the sockets our own multicasts loop back to: those on
+// the mDNS port that joined the group. Each is watched on its own, so that a
+// healthy interface does not hide a dead one.
+func (s *socket) watchedConns() []*ifaceConn {
+	s.ifMu.RLock()
+	defer s.ifMu.RUnlock()
+	var conns []*ifaceConn
+	for _, ic := range s.conns {
+		if ic.joined && ic.act.loopback {
+			conns = append(conns, ic)
+		}
+	}
+	return conns
+}
+
+func (c *mdnsConn) checkStall(now time.Time, ic *ifaceConn, timeout time.Duration) {
+	a := &ic.act
+	sent := a.unanswered.Load()
+	if sent == 0 {
+		a.stalled.Store(false)
+		return
+	}
+
+	since := now.Sub(time.Unix(0, sent))
+	if since < timeout || a.stalled.Swap(true) {
+		return
+	}
+
+	logger.Warn("receive loop appears stalled", slog.String("network", ic.network()), slog.String("interface", ic.ifaceName()), slog.Duration("since", since))
+	c.reportError(&StallError{Network: ic.network(), Interface: ic.ifaceName(), Since: since})
+}
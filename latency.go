@@ -0,0 +1,75 @@
+package simplemdns
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBounds are the upper bounds of the query latency histogram buckets.
+// They span an answer from a responder on the same host to one over a
+// congested Wi-Fi link, where mDNS answers are often delayed by hundreds of
+// milliseconds (RFC 6762 §6).
+var latencyBounds = [...]time.Duration{
+	1 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram is a snapshot of a latency distribution. Its layout follows
+// Prometheus histograms, so it can be exported as one directly: Counts[i] is
+// the number of observations less than or equal to Bounds[i], and Count also
+// includes the observations above the last bound.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64 // cumulative, one per bound
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Mean returns the average observation, or 0 if there are none.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// latencyHistogram records durations into latencyBounds. It is safe for
+// concurrent use and its zero value is ready to use.
+type latencyHistogram struct {
+	buckets [len(latencyBounds) + 1]atomic.Uint64 // last bucket is above all bounds
+	sum     atomic.Int64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *latencyHistogram) snapshot() Histogram {
+	s := Histogram{
+		Bounds: latencyBounds[:],
+		Counts: make([]uint64, len(latencyBounds)),
+	}
+	for i := range h.buckets {
+		s.Count += h.buckets[i].Load()
+		if i < len(s.Counts) {
+			s.Counts[i] = s.Count
+		}
+	}
+	s.Sum = time.Duration(h.sum.Load())
+	return s
+}
@@ -0,0 +1,17 @@
+package simplemdns
+
+import (
+	"log/slog"
+
+	"github.com/oosawy/simplemdns/internal/transport"
+)
+
+var logger = transport.Logger()
+
+// SetLogLevel sets the minimum level of log records emitted by simplemdns,
+// independently of the level of the application's slog handler. The library is
+// chatty at Debug; use e.g. SetLogLevel(slog.LevelWarn) to quiet it without
+// raising the application's global level.
+func SetLogLevel(level slog.Level) {
+	transport.SetLogLevel(level)
+}
@@ -0,0 +1,295 @@
+package simplemdns
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+)
+
+// Probing parameters of RFC 6762 §8.1 and §8.2.
+const (
+	probeCount      = 3
+	probeInterval   = 250 * time.Millisecond
+	probeLostWait   = time.Second // after losing a simultaneous probe tiebreak
+	maxProbeRenames = 15          // give up renaming after this many conflicts
+)
+
+// ErrNameConflict is returned by RegisterService when the name is taken by
+// another host and ResponderOptions.NoRename is set.
+var ErrNameConflict = errors.New("name is already in use on the network")
+
+var errResponderClosed = errors.New("responder closed")
+
+// probe is an ongoing claim of the names of some unique records.
+type probe struct {
+	records   []dns.RR
+	names     map[string]bool // lower-cased owner names of records
+	conflicts chan string     // names another host answered for
+	lost      chan struct{}   // a simultaneous probe won the tiebreak
+}
+
+func newProbe(records []dns.RR) *probe {
+	p := &probe{
+		records: records,
+		names:   make(map[string]bool),
+		lost:    make(chan struct{}, 1),
+	}
+	for _, rr := range records {
+		p.names[strings.ToLower(rr.Header().Name)] = true
+	}
+	p.conflicts = make(chan string, len(p.names))
+	return p
+}
+
+// claim probes for the names of records, which must be unique records, as
+// RFC 6762 §8.1 describes: after a random delay of up to 250ms, three
+// queries 250ms apart carry the proposed records in the authority section.
+// A response with different data for one of the names is a conflict; those
+// names are returned. Losing a tiebreak against a simultaneous probe restarts
+// probing a second later (RFC 6762 §8.2).
+func (r *Responder) claim(records []dns.RR) (conflicts []string, err error) {
+	p := newProbe(records)
+	r.mu.Lock()
+	r.probes = append(r.probes, p)
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.probes = slices.DeleteFunc(r.probes, func(q *probe) bool { return q == p })
+		r.mu.Unlock()
+	}()
+
+	wait := time.Duration(rand.Int64N(int64(probeInterval)))
+	for sent := 0; ; {
+		select {
+		case <-time.After(wait):
+		case name := <-p.conflicts:
+			return drainConflicts(p, name), nil
+		case <-p.lost:
+			logger.Debug("lost simultaneous probe tiebreak; probing again", slog.Any("names", slices.Collect(maps.Keys(p.names))))
+			sent, wait = 0, probeLostWait
+			continue
+		case <-r.done:
+			return nil, errResponderClosed
+		}
+
+		if sent == probeCount {
+			return nil, nil
+		}
+		if err := r.t.SendMsg(p.query()); err != nil {
+			logger.Warn("failed to send probe", slog.Any("error", err))
+		}
+		sent++
+		wait = probeInterval
+	}
+}
+
+// drainConflicts returns first and any other conflicting names already
+// reported.
+func drainConflicts(p *probe, first string) []string {
+	names := []string{first}
+	for {
+		select {
+		case name := <-p.conflicts:
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		default:
+			return names
+		}
+	}
+}
+
+// query builds a probe: a question of type ANY for each name, asking for a
+// unicast response, and the proposed records in the authority section.
+func (p *probe) query() *dns.Msg {
+	msg := new(dns.Msg)
+	seen := make(map[string]bool)
+	for _, rr := range p.records {
+		name := rr.Header().Name
+		if !seen[strings.ToLower(name)] {
+			seen[strings.ToLower(name)] = true
+			msg.Question = append(msg.Question, dns.Question{Name: name, Qtype: dns.TypeANY, Qclass: dns.ClassINET | cacheFlushBit})
+		}
+		msg.Ns = append(msg.Ns, rr)
+	}
+	return msg
+}
+
+// checkConflicts reports to the ongoing probes the records of resp that
+// claim one of their names with different data. Our own looped back
+// responses carry identical data and are not conflicts. Conflicts with
+// records already claimed are only logged. r.mu must be held.
+func (r *Responder) checkConflicts(resp *dns.Msg) {
+	for _, sec := range [][]dns.RR{resp.Answer, resp.Extra} {
+		for _, rr := range sec {
+			name := strings.ToLower(rr.Header().Name)
+			for _, p := range r.probes {
+				if !p.names[name] || slices.ContainsFunc(p.records, func(ours dns.RR) bool { return sameRecord(ours, rr) }) {
+					continue
+				}
+				select {
+				case p.conflicts <- name:
+				default:
+				}
+			}
+			if rr.Header().Class&cacheFlushBit != 0 && r.conflictsWithPublished(rr) {
+				logger.Warn("another host announces a record we own", slog.String("record", rr.String()))
+			}
+		}
+	}
+}
+
+// conflictsWithPublished reports whether rr, a unique record from another
+// host, has the name and type of a unique record of ours but other data.
+// r.mu must be held.
+func (r *Responder) conflictsWithPublished(rr dns.RR) bool {
+	h := rr.Header()
+	var clash bool
+	for _, p := range r.records {
+		ph := p.rr.Header()
+		if p.shared || ph.Rrtype != h.Rrtype || !equalNames(ph.Name, h.Name) {
+			continue
+		}
+		if sameRecord(p.rr, rr) {
+			return false
+		}
+		clash = true
+	}
+	return clash
+}
+
+// checkTiebreak resolves simultaneous probing (RFC 6762 §8.2): when query is
+// another host's probe for a name we are probing, the records of each side
+// are compared and the lexicographically later set wins. An identical set is
+// our own looped back probe. r.mu must be held.
+func (r *Responder) checkTiebreak(query *dns.Msg) {
+	if len(query.Ns) == 0 {
+		return
+	}
+	for _, p := range r.probes {
+		for _, q := range query.Question {
+			name := strings.ToLower(q.Name)
+			if !p.names[name] {
+				continue
+			}
+			if compareRecordSets(recordsNamed(p.records, name), recordsNamed(query.Ns, name)) < 0 {
+				select {
+				case p.lost <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+func recordsNamed(rrs []dns.RR, name string) []dns.RR {
+	var out []dns.RR
+	for _, rr := range rrs {
+		if equalNames(rr.Header().Name, name) {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+// compareRecordSets orders two sets of records with one owner name as RFC
+// 6762 §8.2 requires: each set is sorted by class, type and raw rdata, the
+// sets are compared record by record, and if one runs out first the longer
+// one is later.
+func compareRecordSets(a, b []dns.RR) int {
+	a, b = sortedForTiebreak(a), sortedForTiebreak(b)
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareForTiebreak(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+func sortedForTiebreak(rrs []dns.RR) []dns.RR {
+	rrs = slices.Clone(rrs)
+	slices.SortFunc(rrs, compareForTiebreak)
+	return rrs
+}
+
+func compareForTiebreak(a, b dns.RR) int {
+	ha, hb := a.Header(), b.Header()
+	if c := int(ha.Class&^cacheFlushBit) - int(hb.Class&^cacheFlushBit); c != 0 {
+		return c
+	}
+	if c := int(ha.Rrtype) - int(hb.Rrtype); c != 0 {
+		return c
+	}
+	return bytes.Compare(rdata(a), rdata(b))
+}
+
+// rdata returns the uncompressed wire form of rr's data. It packs a copy,
+// since packing sets the header's Rdlength and rr may be on its way out in a
+// probe at the same time.
+func rdata(rr dns.RR) []byte {
+	rr = dns.Copy(rr)
+	buf := make([]byte, dns.Len(rr)+1)
+	end, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	start, err := dns.PackDomainName(rr.Header().Name, make([]byte, 256), 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	start += 10 // type, class, TTL and rdlength
+	if start > end {
+		return nil
+	}
+	return buf[start:end]
+}
+
+// nextInstanceName returns the name to try after a conflict on instance,
+// following the "Name (2)", "Name (3)" convention of RFC 6763 §13.
+func nextInstanceName(instance string) string {
+	base, n := instance, 1
+	if i := strings.LastIndex(instance, " ("); i >= 0 && strings.HasSuffix(instance, ")") {
+		if v, err := strconv.Atoi(instance[i+2 : len(instance)-1]); err == nil && v > 1 {
+			base, n = instance[:i], v
+		}
+	}
+	suffix := fmt.Sprintf(" (%d)", n+1)
+	return truncateLabel(base, maxLabelLen-len(suffix)) + suffix
+}
+
+// nextHostname returns the host name to try after a conflict on hostname, a
+// fully qualified name in .local: "host.local." becomes "host-2.local.",
+// then "host-3.local.".
+func nextHostname(hostname string) string {
+	label, rest, _ := strings.Cut(hostname, ".")
+	base, n := label, 1
+	if i := strings.LastIndex(label, "-"); i >= 0 {
+		if v, err := strconv.Atoi(label[i+1:]); err == nil && v > 1 {
+			base, n = label[:i], v
+		}
+	}
+	suffix := "-" + strconv.Itoa(n+1)
+	return truncateLabel(base, maxLabelLen-len(suffix)) + suffix + "." + rest
+}
+
+// truncateLabel shortens label to at most n bytes without splitting a UTF-8
+// sequence.
+func truncateLabel(label string, n int) string {
+	if len(label) <= n {
+		return label
+	}
+	for n > 0 && !utf8.RuneStart(label[n]) {
+		n--
+	}
+	return label[:n]
+}
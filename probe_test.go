@@ -0,0 +1,124 @@
+package simplemdns
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+)
+
+func TestCompareRecordSets(t *testing.T) {
+	a := func(ip string) dns.RR { return cacheA("host.local.", ip, 120, true) }
+	aaaa := func(ip string) dns.RR {
+		return &dns.AAAA{Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 120}, AAAA: net.ParseIP(ip)}
+	}
+	chaos := &dns.A{Hdr: dns.RR_Header{Name: "host.local.", Rrtype: dns.TypeA, Class: dns.ClassCHAOS, Ttl: 120}, A: net.ParseIP("10.0.0.1")}
+
+	tests := []struct {
+		name string
+		a, b []dns.RR
+		want int // sign of the result
+	}{
+		// the example of RFC 6762 §8.2
+		{"rfc example", []dns.RR{a("169.254.99.200")}, []dns.RR{a("169.254.200.50")}, -1},
+		{"identical", []dns.RR{a("10.0.0.1")}, []dns.RR{a("10.0.0.1")}, 0},
+		{"ttl and cache-flush bit ignored", []dns.RR{a("10.0.0.1")}, []dns.RR{cacheA("host.local.", "10.0.0.1", 10, false)}, 0},
+		{"class first", []dns.RR{chaos}, []dns.RR{a("10.0.0.2")}, 1},
+		{"type before rdata", []dns.RR{aaaa("::1")}, []dns.RR{a("255.255.255.255")}, 1},
+		{"order within a set ignored", []dns.RR{a("10.0.0.2"), a("10.0.0.1")}, []dns.RR{a("10.0.0.1"), a("10.0.0.2")}, 0},
+		{"sorted before comparing", []dns.RR{a("10.0.0.3"), a("10.0.0.1")}, []dns.RR{a("10.0.0.2")}, -1},
+		{"longer set later", []dns.RR{a("10.0.0.1"), a("10.0.0.2")}, []dns.RR{a("10.0.0.1")}, 1},
+		{"empty", nil, []dns.RR{a("10.0.0.1")}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sign(compareRecordSets(tt.a, tt.b)); got != tt.want {
+				t.Errorf("compareRecordSets(a, b) = %d, want %d", got, tt.want)
+			}
+			if got := sign(compareRecordSets(tt.b, tt.a)); got != -tt.want {
+				t.Errorf("compareRecordSets(b, a) = %d, want %d", got, -tt.want)
+			}
+		})
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func TestRdata(t *testing.T) {
+	srv := &dns.SRV{
+		Hdr:    dns.RR_Header{Name: "Web._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 120},
+		Weight: 0x0102, Port: 80, Target: "h.local.",
+	}
+	tests := []struct {
+		name string
+		rr   dns.RR
+		want []byte
+	}{
+		{"A", cacheA("host.local.", "10.1.2.3", 120, true), []byte{10, 1, 2, 3}},
+		// names in rdata stay uncompressed and keep their case
+		{"SRV", srv, []byte{0, 0, 1, 2, 0, 80, 1, 'h', 5, 'l', 'o', 'c', 'a', 'l', 0}},
+		{"TXT", &dns.TXT{Hdr: dns.RR_Header{Name: "Web._http._tcp.local.", Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{"a=1"}}, []byte{3, 'a', '=', '1'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rdata(tt.rr); !bytes.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextInstanceName(t *testing.T) {
+	long := strings.Repeat("a", maxLabelLen)
+	tests := []struct {
+		in, want string
+	}{
+		{"Printer", "Printer (2)"},
+		{"Printer (2)", "Printer (3)"},
+		{"Printer (9)", "Printer (10)"},
+		{"Printer (1)", "Printer (1) (2)"},
+		{"Printer (x)", "Printer (x) (2)"},
+		{"Printer(2)", "Printer(2) (2)"},
+		{long, long[:maxLabelLen-4] + " (2)"},
+		// truncation keeps whole characters
+		{strings.Repeat("a", 58) + "é", strings.Repeat("a", 58) + " (2)"},
+	}
+	for _, tt := range tests {
+		if got := nextInstanceName(tt.in); got != tt.want {
+			t.Errorf("nextInstanceName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if got := nextInstanceName(tt.in); len(got) > maxLabelLen || !utf8.ValidString(got) {
+			t.Errorf("nextInstanceName(%q) = %q is not a valid label", tt.in, got)
+		}
+	}
+}
+
+func TestNextHostname(t *testing.T) {
+	long := strings.Repeat("h", maxLabelLen)
+	tests := []struct {
+		in, want string
+	}{
+		{"host.local.", "host-2.local."},
+		{"host-2.local.", "host-3.local."},
+		{"host-9.local.", "host-10.local."},
+		{"host-1.local.", "host-1-2.local."},
+		{"my-host.local.", "my-host-2.local."},
+		{long + ".local.", long[:maxLabelLen-2] + "-2.local."},
+	}
+	for _, tt := range tests {
+		if got := nextHostname(tt.in); got != tt.want {
+			t.Errorf("nextHostname(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
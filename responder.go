@@ -0,0 +1,567 @@
+package simplemdns
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/oosawy/simplemdns/internal/transport"
+)
+
+// Default record TTLs (RFC 6762 §10): records naming a host, whose addresses
+// may change, get two minutes; the others 75 minutes.
+const (
+	hostRecordTTL  = 120
+	otherRecordTTL = 4500
+)
+
+const (
+	// maxPacketSize is the largest mDNS packet we accept; queries with long
+	// known-answer lists may exceed the Ethernet MTU (RFC 6762 §17).
+	maxPacketSize = 9000
+	// legacyUnicastTTL caps TTLs in replies to legacy (non-5353) queriers
+	// (RFC 6762 §6.7).
+	legacyUnicastTTL = 10
+	// multicastRateLimit is the minimum interval between multicasts of a
+	// record in answer to queries (RFC 6762 §6.2).
+	multicastRateLimit = time.Second
+	// probeDefenseRateLimit replaces multicastRateLimit when answering probes
+	// (RFC 6762 §6.2).
+	probeDefenseRateLimit = 250 * time.Millisecond
+	// announceInterval separates the announcements of newly published
+	// records (RFC 6762 §8.3).
+	announceInterval = time.Second
+	// announceCount is the number of announcements of newly published records.
+	announceCount = 2
+)
+
+// ResponderOptions controls how the responder creates its transport. The
+// responder always binds the mDNS port, since queries are sent there.
+type ResponderOptions struct {
+	IPVersion      transport.IPVersion  // zero to detect from the addresses of the interfaces in use
+	Interfaces     []net.Interface      // nil or empty for all available multicast interfaces
+	IncludeVirtual bool                 // when Interfaces is empty, also use docker/veth/tun/VM interfaces, which are skipped by default
+	WriteTimeout   time.Duration        // per-send deadline; defaults to 1s, negative disables
+	LogMessages    bool                 // log every sent/received message in full (questions and records) at Debug
+	PacketDump     io.Writer            // if set, hex dumps of raw sent/received packets are written here
+	Compliance     transport.Compliance // Lenient (default) or Strict RFC 6762 checking of received traffic
+	Hostname       string               // host name for the address records of RegisterService, under .local; defaults to the system host name
+	NoRename       bool                 // make RegisterService fail with ErrNameConflict instead of picking a new name when one is taken
+	StaticIfaces   bool                 // don't follow interfaces coming up, going down or changing addresses; see Responder
+
+	// Broker shares port 5353 with other simplemdns processes on the host;
+	// see ClientOptions.Broker.
+	Broker string
+}
+
+func (o ResponderOptions) withDefaults() ResponderOptions {
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = time.Second
+	}
+	if o.Hostname == "" {
+		o.Hostname = defaultHostname()
+	}
+	o.Hostname = dns.Fqdn(o.Hostname)
+	if !strings.HasSuffix(strings.ToLower(o.Hostname), ".local.") {
+		o.Hostname += "local."
+	}
+	return o
+}
+
+// published is a record the responder answers for.
+type published struct {
+	rr            dns.RR // class without the cache-flush bit
+	shared        bool   // shared records (PTR) may be published by several hosts
+	lastMulticast time.Time
+}
+
+// Responder answers mDNS queries for the records published on it and
+// announces them, as RFC 6762 describes for a responder. Unless
+// ResponderOptions.StaticIfaces is set, it follows interfaces coming and
+// going: when the set changes, the host's address records are brought up to
+// date and all records are announced again, so hosts on a new interface
+// learn them.
+type Responder struct {
+	t    transport.Transport
+	opts ResponderOptions
+
+	hostMu        sync.Mutex
+	hostname      string // may change if taken; guarded by hostMu
+	hostPublished bool
+
+	mu      sync.Mutex
+	records []*published
+	probes  []*probe
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewResponder creates a responder bound to the mDNS port. Accepts zero or
+// one ResponderOptions.
+func NewResponder(opts ...ResponderOptions) (*Responder, error) {
+	var o ResponderOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	o = o.withDefaults()
+
+	r := &Responder{
+		opts:     o,
+		hostname: o.Hostname,
+		done:     make(chan struct{}),
+	}
+
+	t, err := transport.New(transport.Options{
+		IPVersion:      o.IPVersion,
+		BindTo:         BindMDNSPort,
+		JoinIfaces:     o.Interfaces,
+		IncludeVirtual: o.IncludeVirtual,
+		UDPRecvBufSize: maxPacketSize,
+		WriteTimeout:   o.WriteTimeout,
+		StallTimeout:   -1, // a responder may legitimately hear nothing for long
+		LogMessages:    o.LogMessages,
+		PacketDump:     o.PacketDump,
+		Broker:         o.Broker,
+		MsgsChBufSize:  64,
+		Compliance:     o.Compliance,
+
+		WatchInterfaces:     !o.StaticIfaces,
+		OnInterfacesChanged: r.interfacesChanged,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.t = t
+
+	r.wg.Go(r.serve)
+	return r, nil
+}
+
+func (r *Responder) serve() {
+	msgs := r.t.Messages()
+	for {
+		select {
+		case m, ok := <-msgs:
+			if !ok {
+				return
+			}
+			if m.Response {
+				r.mu.Lock()
+				r.checkConflicts(m.Msg)
+				r.mu.Unlock()
+				continue
+			}
+			r.mu.Lock()
+			r.checkTiebreak(m.Msg)
+			r.mu.Unlock()
+			r.answer(m.Msg, m.Src)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Errors returns a channel of asynchronous transport errors. It is closed
+// when the responder is closed.
+func (r *Responder) Errors() <-chan error {
+	return r.t.Errors()
+}
+
+// Close sends goodbye packets for all published records and releases the
+// transport.
+func (r *Responder) Close() (err error) {
+	r.closeOnce.Do(func() {
+		r.mu.Lock()
+		close(r.done)
+		r.mu.Unlock()
+		r.wg.Wait()
+
+		r.mu.Lock()
+		rrs := make([]dns.RR, 0, len(r.records))
+		for _, p := range r.records {
+			rrs = append(rrs, p.rr)
+		}
+		r.records = nil
+		r.mu.Unlock()
+		if len(rrs) > 0 {
+			if gerr := r.goodbye(rrs); gerr != nil {
+				logger.Debug("failed to send goodbye", slog.Any("error", gerr))
+			}
+		}
+
+		err = r.t.Close()
+	})
+	return
+}
+
+// Publish starts answering for rrs and announces them. PTR records are
+// treated as shared, as DNS-SD uses them to list the many instances of a
+// service type; all other records are unique to this host and carry the
+// cache-flush bit on the wire (RFC 6762 §10.2). A zero TTL is replaced by
+// the RFC 6762 §10 default: 120s for A, AAAA, SRV and HINFO records, 75
+// minutes for the others.
+//
+// Records must have class IN. Publishing a record that is already published
+// updates its TTL. Publish does not probe (RFC 6762 §8): the names of unique
+// records are assumed to be ours, as RegisterService makes sure of.
+func (r *Responder) Publish(rrs ...dns.RR) error {
+	if len(rrs) == 0 {
+		return nil
+	}
+	prepared := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		if rr == nil {
+			return errors.New("nil record")
+		}
+		rr = dns.Copy(rr)
+		h := rr.Header()
+		if h.Class&^cacheFlushBit != dns.ClassINET {
+			return errors.New("record " + h.Name + " does not have class IN")
+		}
+		h.Name = dns.Fqdn(h.Name)
+		h.Class = dns.ClassINET
+		if h.Ttl == 0 {
+			h.Ttl = defaultTTL(h.Rrtype)
+		}
+		prepared = append(prepared, rr)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed() {
+		return errResponderClosed
+	}
+	r.add(prepared)
+	// started under mu so that Close cannot be waiting already
+	r.wg.Go(func() { r.announce(prepared) })
+	return nil
+}
+
+// add starts answering for rrs, which are prepared as Publish does. r.mu
+// must be held.
+func (r *Responder) add(rrs []dns.RR) {
+	for _, rr := range rrs {
+		if p := r.lookup(rr); p != nil {
+			p.rr.Header().Ttl = rr.Header().Ttl
+			continue
+		}
+		r.records = append(r.records, &published{rr: rr, shared: rr.Header().Rrtype == dns.TypePTR})
+	}
+}
+
+// closed reports whether Close has begun. r.mu must be held for the answer
+// to hold.
+func (r *Responder) closed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// interfacesChanged is the transport's OnInterfacesChanged hook.
+func (r *Responder) interfacesChanged([]net.Interface) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed() {
+		r.wg.Go(r.reannounce)
+	}
+}
+
+// reannounce updates the host's address records to the addresses now in use
+// and announces every record anew.
+func (r *Responder) reannounce() {
+	r.updateHostRecords()
+
+	r.mu.Lock()
+	rrs := make([]dns.RR, 0, len(r.records))
+	for _, p := range r.records {
+		rrs = append(rrs, p.rr)
+	}
+	r.mu.Unlock()
+	if len(rrs) > 0 {
+		logger.Info("interfaces changed; announcing records again", slog.Int("records", len(rrs)))
+		r.announce(rrs)
+	}
+}
+
+// Unpublish stops answering for rrs and sends goodbye packets for them (RFC
+// 6762 §10.1). Records not published are ignored.
+func (r *Responder) Unpublish(rrs ...dns.RR) error {
+	r.mu.Lock()
+	var gone []dns.RR
+	for _, rr := range rrs {
+		for i, p := range r.records {
+			if sameRecord(p.rr, rr) {
+				gone = append(gone, p.rr)
+				r.records = append(r.records[:i], r.records[i+1:]...)
+				break
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	if len(gone) == 0 {
+		return nil
+	}
+	return r.goodbye(gone)
+}
+
+// lookup returns the published record with the same name, type and data as
+// rr. r.mu must be held.
+func (r *Responder) lookup(rr dns.RR) *published {
+	for _, p := range r.records {
+		if sameRecord(p.rr, rr) {
+			return p
+		}
+	}
+	return nil
+}
+
+// announce multicasts rrs announceCount times, announceInterval apart.
+func (r *Responder) announce(rrs []dns.RR) {
+	for i := 0; i < announceCount; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(announceInterval):
+			case <-r.done:
+				return
+			}
+		}
+
+		r.mu.Lock()
+		var answers []*published
+		for _, rr := range rrs {
+			if p := r.lookup(rr); p != nil {
+				p.lastMulticast = time.Now()
+				answers = append(answers, p)
+			}
+		}
+		r.mu.Unlock()
+		if len(answers) == 0 {
+			return // unpublished meanwhile
+		}
+
+		if err := r.t.SendMsg(r.response(answers, nil, true)); err != nil {
+			logger.Warn("failed to announce records", slog.Any("error", err))
+		}
+	}
+}
+
+// goodbye multicasts rrs with a zero TTL so caches drop them.
+func (r *Responder) goodbye(rrs []dns.RR) error {
+	msg := new(dns.Msg)
+	msg.Response = true
+	msg.Authoritative = true
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		msg.Answer = append(msg.Answer, rr)
+	}
+	return r.t.SendMsg(msg)
+}
+
+// answer responds to query, received from from. Answers the querier already
+// holds are suppressed (RFC 6762 §7.1). Legacy queriers, which send from a
+// port other than 5353, get a conventional unicast reply (RFC 6762 §6.7);
+// questions with the unicast-response bit are answered to the querier
+// directly (RFC 6762 §5.4); the rest is answered by multicast, delayed by
+// 20-120ms if it includes shared records (RFC 6762 §6). Probes for our names
+// are defended by multicast without delay (RFC 6762 §8.1).
+func (r *Responder) answer(query *dns.Msg, from *net.UDPAddr) {
+	legacy := from != nil && from.Port != 5353
+	probe := len(query.Ns) > 0
+	rateLimit := multicastRateLimit
+	if probe {
+		rateLimit = probeDefenseRateLimit
+	}
+
+	var multicast, unicast []*published
+	r.mu.Lock()
+	now := time.Now()
+	for _, q := range query.Question {
+		qu := q.Qclass&cacheFlushBit != 0
+		class := q.Qclass &^ cacheFlushBit
+		if class != dns.ClassINET && class != dns.ClassANY {
+			continue
+		}
+		for _, p := range r.records {
+			h := p.rr.Header()
+			if q.Qtype != dns.TypeANY && q.Qtype != h.Rrtype || !equalNames(q.Name, h.Name) {
+				continue
+			}
+			if knownAnswer(query.Answer, p.rr) {
+				continue
+			}
+			switch {
+			case legacy || (qu && from != nil && !probe):
+				unicast = appendOnce(unicast, p)
+			case now.Sub(p.lastMulticast) < rateLimit:
+				// just multicast; the querier has it
+			default:
+				multicast = appendOnce(multicast, p)
+			}
+		}
+	}
+	var delay bool
+	for _, p := range multicast {
+		p.lastMulticast = now
+		delay = delay || p.shared && !probe
+	}
+	r.mu.Unlock()
+
+	if len(unicast) > 0 {
+		resp := r.response(unicast, nil, !legacy)
+		if legacy {
+			r.legacyReply(resp, query)
+		}
+		if err := r.t.SendMsgTo(resp, from); err != nil {
+			logger.Debug("failed to send unicast response", slog.String("to", from.String()), slog.Any("error", err))
+		}
+	}
+
+	if len(multicast) > 0 {
+		resp := r.response(multicast, query.Answer, true)
+		if !delay {
+			r.send(resp)
+			return
+		}
+		wait := time.Duration(20+rand.IntN(101)) * time.Millisecond
+		r.wg.Go(func() {
+			select {
+			case <-time.After(wait):
+				r.send(resp)
+			case <-r.done:
+			}
+		})
+	}
+}
+
+func (r *Responder) send(resp *dns.Msg) {
+	if err := r.t.SendMsg(resp); err != nil {
+		logger.Debug("failed to send multicast response", slog.Any("error", err))
+	}
+}
+
+// legacyReply turns resp into a conventional DNS reply to query: same ID,
+// the question echoed, TTLs capped and no cache-flush bits (RFC 6762 §6.7).
+func (r *Responder) legacyReply(resp, query *dns.Msg) {
+	resp.Id = query.Id
+	resp.Question = query.Question
+	for _, sec := range [][]dns.RR{resp.Answer, resp.Extra} {
+		for _, rr := range sec {
+			h := rr.Header()
+			h.Class &^= cacheFlushBit
+			h.Ttl = min(h.Ttl, legacyUnicastTTL)
+		}
+	}
+}
+
+// response builds a response carrying answers, with the records that make
+// them useful (addresses of an SRV target, SRV and TXT of a PTR target) as
+// additionals (RFC 6763 §12), minus those in known. With flush, unique
+// records carry the cache-flush bit.
+func (r *Responder) response(answers []*published, known []dns.RR, flush bool) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.Response = true
+	msg.Authoritative = true
+
+	wire := func(p *published) dns.RR {
+		rr := dns.Copy(p.rr)
+		if flush && !p.shared {
+			rr.Header().Class |= cacheFlushBit
+		}
+		return rr
+	}
+	// add rewrites the records of published entries under r.mu
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range answers {
+		msg.Answer = append(msg.Answer, wire(p))
+	}
+
+	var extra []*published
+	addFor := func(name string, types ...uint16) {
+		for _, p := range r.records {
+			h := p.rr.Header()
+			if !equalNames(h.Name, name) || !slices.Contains(types, h.Rrtype) {
+				continue
+			}
+			if slices.Contains(answers, p) || knownAnswer(known, p.rr) {
+				continue
+			}
+			extra = appendOnce(extra, p)
+		}
+	}
+	for _, p := range answers {
+		switch rr := p.rr.(type) {
+		case *dns.PTR:
+			addFor(rr.Ptr, dns.TypeSRV, dns.TypeTXT)
+			for _, srv := range r.records {
+				if s, ok := srv.rr.(*dns.SRV); ok && equalNames(s.Hdr.Name, rr.Ptr) {
+					addFor(s.Target, dns.TypeA, dns.TypeAAAA)
+				}
+			}
+		case *dns.SRV:
+			addFor(rr.Target, dns.TypeA, dns.TypeAAAA)
+		}
+	}
+	for _, p := range extra {
+		msg.Extra = append(msg.Extra, wire(p))
+	}
+	return msg
+}
+
+// knownAnswer reports whether known, the Answer section of a query, holds rr
+// with at least half its TTL left (RFC 6762 §7.1).
+func knownAnswer(known []dns.RR, rr dns.RR) bool {
+	for _, k := range known {
+		if sameRecord(k, rr) && k.Header().Ttl >= rr.Header().Ttl/2 {
+			return true
+		}
+	}
+	return false
+}
+
+// sameRecord reports whether a and b have the same name, type, class and
+// data, ignoring TTLs and the cache-flush bit.
+func sameRecord(a, b dns.RR) bool {
+	ha, hb := a.Header(), b.Header()
+	if ha.Rrtype != hb.Rrtype || ha.Class&^cacheFlushBit != hb.Class&^cacheFlushBit || !equalNames(ha.Name, hb.Name) {
+		return false
+	}
+	// IsDuplicate compares classes too, so strip the bit from copies
+	if ha.Class != hb.Class {
+		a, b = dns.Copy(a), dns.Copy(b)
+		a.Header().Class &^= cacheFlushBit
+		b.Header().Class &^= cacheFlushBit
+	}
+	return dns.IsDuplicate(a, b)
+}
+
+func defaultTTL(rrtype uint16) uint32 {
+	switch rrtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeSRV, dns.TypeHINFO:
+		return hostRecordTTL
+	default:
+		return otherRecordTTL
+	}
+}
+
+func appendOnce[T comparable](s []T, v T) []T {
+	if slices.Contains(s, v) {
+		return s
+	}
+	return append(s, v)
+}
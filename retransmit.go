@@ -0,0 +1,98 @@
+package simplemdns
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Query timing of RFC 6762 §5.2.
+const (
+	retransmitFirst  = time.Second
+	retransmitMax    = time.Hour
+	firstQueryJitter = 100 * time.Millisecond // on top of firstQueryDelay
+	firstQueryDelay  = 20 * time.Millisecond  // before the first question of a continuous query
+)
+
+// QueryOptions tunes how a single query is sent. The zero value follows
+// RFC 6762 §5.2: the question is multicast and repeated after 1s, 2s, 4s and
+// so on, the interval doubling up to an hour, until the query returns.
+type QueryOptions struct {
+	FirstInterval time.Duration // wait before the first retransmission; defaults to 1s, negative sends the question only once
+	MaxInterval   time.Duration // cap on the doubling interval; defaults to 1 hour
+
+	// Unicast sets the unicast-response (QU) bit on the first question, so
+	// that responders reply to the client's port directly instead of
+	// multicasting to the whole link. Retransmissions ask for multicast
+	// again, in case the unicast reply was lost or the responder only just
+	// joined (RFC 6762 §5.4). It only makes a difference when the client
+	// is bound to the mDNS port: queries from any other port always get
+	// unicast replies.
+	Unicast bool
+}
+
+func (o QueryOptions) withDefaults() QueryOptions {
+	if o.FirstInterval == 0 {
+		o.FirstInterval = retransmitFirst
+	}
+	if o.MaxInterval == 0 {
+		o.MaxInterval = retransmitMax
+	}
+	if o.MaxInterval < o.FirstInterval {
+		o.MaxInterval = o.FirstInterval
+	}
+	return o
+}
+
+// queryOptions returns the first of opts, or the defaults.
+func queryOptions(opts []QueryOptions) QueryOptions {
+	var o QueryOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	return o.withDefaults()
+}
+
+// first returns q as the first question of a query, with the
+// unicast-response bit set if o asks for it.
+func (o QueryOptions) first(q dns.Question) dns.Question {
+	if o.Unicast {
+		q.Qclass |= cacheFlushBit
+	}
+	return q
+}
+
+// retransmitter times the retransmissions of a query.
+type retransmitter struct {
+	timer    *time.Timer // nil if retransmission is disabled
+	interval time.Duration
+	max      time.Duration
+}
+
+func newRetransmitter(o QueryOptions) *retransmitter {
+	if o.FirstInterval < 0 {
+		return &retransmitter{}
+	}
+	return &retransmitter{timer: time.NewTimer(o.FirstInterval), interval: o.FirstInterval, max: o.MaxInterval}
+}
+
+// C fires when the question is due again; it never fires if retransmission
+// is disabled.
+func (r *retransmitter) C() <-chan time.Time {
+	if r.timer == nil {
+		return nil
+	}
+	return r.timer.C
+}
+
+// next doubles the interval and schedules the following retransmission.
+func (r *retransmitter) next() {
+	r.interval = min(2*r.interval, r.max)
+	r.timer.Reset(r.interval)
+}
+
+func (r *retransmitter) stop() {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+}
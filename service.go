@@ -0,0 +1,294 @@
+package simplemdns
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/oosawy/simplemdns/internal/transport"
+)
+
+// servicesEnumName lists the service types present on the link (RFC 6763 §9).
+const servicesEnumName = "_services._dns-sd._udp.local."
+
+// maxLabelLen is the longest DNS label (RFC 1035 §2.3.4).
+const maxLabelLen = 63
+
+// Service is a service instance registered with RegisterService.
+type Service struct {
+	r       *Responder
+	name    string
+	records []dns.RR
+}
+
+// Name returns the full name of the service instance, e.g.
+// "My Printer._ipp._tcp.local.", with dots in the instance escaped.
+func (s *Service) Name() string {
+	return s.name
+}
+
+// Records returns the PTR, SRV and TXT records of the service. The host's
+// address records are not included, as they are shared by all services.
+func (s *Service) Records() []dns.RR {
+	return s.records
+}
+
+// Unregister withdraws the service, sending goodbye packets for its records.
+func (s *Service) Unregister() error {
+	return s.r.Unpublish(s.records...)
+}
+
+// RegisterService publishes a DNS-SD service instance (RFC 6763): a PTR
+// record from serviceType to the instance, the SRV and TXT records of the
+// instance, the service type in the _services._dns-sd._udp enumeration, and
+// the host's A and AAAA records for the addresses of the interfaces in use.
+// serviceType is like "_http._tcp", optionally followed by ".local."; txt
+// holds "key=value" strings and may be nil.
+//
+// The instance and host names are probed first (RFC 6762 §8), which takes
+// about a second. A name found in use is replaced by "Name (2)" or "host-2"
+// and so on, unless ResponderOptions.NoRename is set; Service.Name tells the
+// name obtained.
+func (r *Responder) RegisterService(instance, serviceType string, port int, txt []string) (*Service, error) {
+	if instance == "" || len(instance) > maxLabelLen {
+		return nil, fmt.Errorf("instance name must be 1 to %d bytes", maxLabelLen)
+	}
+	if port <= 0 || port > 0xffff {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+	serviceType, err := normalizeServiceType(serviceType)
+	if err != nil {
+		return nil, err
+	}
+	if len(txt) == 0 {
+		txt = []string{""}
+	}
+	if err := ValidateTXT(txt); err != nil {
+		return nil, err
+	}
+
+	hostname, err := r.publishHost()
+	if err != nil {
+		return nil, err
+	}
+
+	var name string
+	var records []dns.RR
+	for renames := 0; ; renames++ {
+		name = escapeInstance(instance) + "." + serviceType
+		records = []dns.RR{
+			&dns.SRV{
+				Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: hostRecordTTL},
+				Port:   uint16(port),
+				Target: hostname,
+			},
+			&dns.TXT{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: otherRecordTTL},
+				Txt: txt,
+			},
+		}
+		conflicts, err := r.claim(records)
+		if err != nil {
+			return nil, err
+		}
+		if len(conflicts) == 0 {
+			break
+		}
+		if r.opts.NoRename {
+			return nil, fmt.Errorf("%w: %s", ErrNameConflict, name)
+		}
+		if renames == maxProbeRenames {
+			return nil, fmt.Errorf("%w: gave up on %s after %d renames", ErrNameConflict, name, renames)
+		}
+		next := nextInstanceName(instance)
+		logger.Info("service name in use; renaming", slog.String("name", instance), slog.String("new", next))
+		instance = next
+	}
+
+	records = append([]dns.RR{&dns.PTR{
+		Hdr: dns.RR_Header{Name: serviceType, Rrtype: dns.TypePTR, Class: dns.ClassINET},
+		Ptr: name,
+	}}, records...)
+	enum := &dns.PTR{
+		Hdr: dns.RR_Header{Name: servicesEnumName, Rrtype: dns.TypePTR, Class: dns.ClassINET},
+		Ptr: serviceType,
+	}
+	if err := r.Publish(append(records, enum)...); err != nil {
+		return nil, err
+	}
+
+	// the enumeration PTR is shared with other instances of the type, so it
+	// outlives this one
+	return &Service{r: r, name: name, records: records}, nil
+}
+
+// normalizeServiceType checks that serviceType has the "_name._tcp" or
+// "_name._udp" form (RFC 6763 §7) and returns it fully qualified in "local.".
+func normalizeServiceType(serviceType string) (string, error) {
+	serviceType = dns.Fqdn(serviceType)
+	if strings.HasSuffix(strings.ToLower(serviceType), ".local.") {
+		serviceType = serviceType[:len(serviceType)-len("local.")]
+	}
+	labels := dns.SplitDomainName(serviceType)
+	if len(labels) != 2 ||
+		len(labels[0]) < 2 || len(labels[0]) > 16 || labels[0][0] != '_' ||
+		!equalNames(labels[1], "_tcp") && !equalNames(labels[1], "_udp") {
+		return "", fmt.Errorf("invalid service type %q; want e.g. \"_http._tcp\"", serviceType)
+	}
+	return labels[0] + "." + strings.ToLower(labels[1]) + ".local.", nil
+}
+
+// publishHost claims and publishes the host's address records, once, and
+// returns the host name, which is renamed if taken. The records are shared
+// by all registered services and stay published until the responder is
+// closed.
+func (r *Responder) publishHost() (string, error) {
+	r.hostMu.Lock()
+	defer r.hostMu.Unlock()
+	if r.hostPublished {
+		return r.hostname, nil
+	}
+
+	addrs, err := r.hostAddrs()
+	if err != nil {
+		return "", err
+	}
+	for renames := 0; ; renames++ {
+		rrs := hostRecords(r.hostname, addrs)
+		conflicts, err := r.claim(rrs)
+		if err != nil {
+			return "", err
+		}
+		if len(conflicts) == 0 {
+			if err := r.Publish(rrs...); err != nil {
+				return "", err
+			}
+			r.hostPublished = true
+			return r.hostname, nil
+		}
+		if r.opts.NoRename {
+			return "", fmt.Errorf("%w: %s", ErrNameConflict, r.hostname)
+		}
+		if renames == maxProbeRenames {
+			return "", fmt.Errorf("%w: gave up on %s after %d renames", ErrNameConflict, r.hostname, renames)
+		}
+		next := nextHostname(r.hostname)
+		logger.Info("host name in use; renaming", slog.String("name", r.hostname), slog.String("new", next))
+		r.hostname = next
+	}
+}
+
+// hostRecords returns the A and AAAA records of hostname for addrs.
+func hostRecords(hostname string, addrs []net.IP) []dns.RR {
+	var rrs []dns.RR
+	for _, ip := range addrs {
+		if ip4 := ip.To4(); ip4 != nil {
+			rrs = append(rrs, &dns.A{Hdr: dns.RR_Header{Name: hostname, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: hostRecordTTL}, A: ip4})
+		} else {
+			rrs = append(rrs, &dns.AAAA{Hdr: dns.RR_Header{Name: hostname, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: hostRecordTTL}, AAAA: ip})
+		}
+	}
+	return rrs
+}
+
+// updateHostRecords replaces the published address records of the host, if
+// any, with those of the addresses now in use, sending goodbyes for the
+// addresses gone. The name is ours already, so new addresses are not probed.
+func (r *Responder) updateHostRecords() {
+	r.hostMu.Lock()
+	defer r.hostMu.Unlock()
+	if !r.hostPublished {
+		return
+	}
+	addrs, err := r.hostAddrs()
+	if err != nil {
+		logger.Warn("keeping the previous host addresses", slog.Any("error", err))
+		return
+	}
+	current := hostRecords(r.hostname, addrs)
+
+	r.mu.Lock()
+	if r.closed() {
+		r.mu.Unlock()
+		return
+	}
+	var gone []dns.RR
+	r.records = slices.DeleteFunc(r.records, func(p *published) bool {
+		h := p.rr.Header()
+		stale := (h.Rrtype == dns.TypeA || h.Rrtype == dns.TypeAAAA) && equalNames(h.Name, r.hostname) &&
+			!slices.ContainsFunc(current, func(rr dns.RR) bool { return sameRecord(rr, p.rr) })
+		if stale {
+			gone = append(gone, p.rr)
+		}
+		return stale
+	})
+	r.add(current)
+	r.mu.Unlock()
+
+	if len(gone) > 0 {
+		if err := r.goodbye(gone); err != nil {
+			logger.Debug("failed to send goodbye", slog.Any("error", err))
+		}
+	}
+}
+
+// hostAddrs returns the addresses of the interfaces the responder uses,
+// of the families it uses.
+func (r *Responder) hostAddrs() ([]net.IP, error) {
+	ifaces := r.opts.Interfaces
+	if len(ifaces) == 0 {
+		all, err := transport.MulticastInterfaces()
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range all {
+			if r.opts.IncludeVirtual || !transport.IsVirtualInterface(&iface) {
+				ifaces = append(ifaces, iface)
+			}
+		}
+		if len(ifaces) == 0 {
+			// as the transport does, use virtual interfaces if that is all there is
+			ifaces = all
+		}
+	}
+
+	var ips []net.IP
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.IsLoopback() {
+				continue
+			}
+			is4 := ipnet.IP.To4() != nil
+			if is4 && r.opts.IPVersion == IPv6 || !is4 && r.opts.IPVersion == IPv4 {
+				continue
+			}
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no addresses to publish for " + r.hostname)
+	}
+	return ips, nil
+}
+
+// defaultHostname derives a .local host name from the system host name.
+func defaultHostname() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		h = "simplemdns"
+	}
+	h, _, _ = strings.Cut(h, ".")
+	h = strings.ReplaceAll(h, " ", "-")
+	return h + ".local."
+}
@@ -0,0 +1,96 @@
+// Package servicetype lists well-known DNS-SD service types with their
+// conventional ports and TXT keys, for use with both browsing and
+// registration. Types are given without the domain, e.g. "_http._tcp".
+package servicetype
+
+import (
+	"slices"
+	"strings"
+)
+
+// Well-known service types.
+const (
+	HTTP             = "_http._tcp"
+	HTTPS            = "_https._tcp"
+	SSH              = "_ssh._tcp"
+	SFTP             = "_sftp-ssh._tcp"
+	FTP              = "_ftp._tcp"
+	SMB              = "_smb._tcp"
+	AFP              = "_afpovertcp._tcp"
+	NFS              = "_nfs._tcp"
+	IPP              = "_ipp._tcp"
+	IPPS             = "_ipps._tcp"
+	LPD              = "_printer._tcp"
+	PDLDatastream    = "_pdl-datastream._tcp"
+	Scanner          = "_uscan._tcp"
+	AirPlay          = "_airplay._tcp"
+	RAOP             = "_raop._tcp"
+	GoogleCast       = "_googlecast._tcp"
+	SpotifyConnect   = "_spotify-connect._tcp"
+	HomeKit          = "_hap._tcp"
+	Matter           = "_matter._tcp"
+	MatterCommission = "_matterc._udp"
+	RFB              = "_rfb._tcp"
+	Workstation      = "_workstation._tcp"
+	DeviceInfo       = "_device-info._tcp"
+
+	// Services is the DNS-SD meta-type; a PTR query for it enumerates the
+	// service types present on the network (RFC 6763 §9).
+	Services = "_services._dns-sd._udp"
+)
+
+// Info describes a service type.
+type Info struct {
+	Type        string // e.g. "_http._tcp"
+	Description string
+	Port        int      // conventional port; 0 if there is none
+	TXTKeys     []string // keys commonly found in the TXT record
+}
+
+var registry = []Info{
+	{HTTP, "Web server", 80, []string{"path", "u", "p"}},
+	{HTTPS, "Secure web server", 443, []string{"path"}},
+	{SSH, "SSH remote login", 22, nil},
+	{SFTP, "SFTP file transfer", 22, nil},
+	{FTP, "FTP file transfer", 21, []string{"path", "u", "p"}},
+	{SMB, "SMB/CIFS file sharing", 445, nil},
+	{AFP, "Apple Filing Protocol", 548, nil},
+	{NFS, "NFS file sharing", 2049, []string{"path"}},
+	{IPP, "Internet Printing Protocol", 631, []string{"txtvers", "rp", "ty", "pdl", "note", "product", "adminurl", "UUID", "Color", "Duplex", "URF"}},
+	{IPPS, "Internet Printing Protocol over TLS", 631, []string{"txtvers", "rp", "ty", "pdl", "note", "product", "adminurl", "UUID", "Color", "Duplex", "URF"}},
+	{LPD, "LPD printing", 515, []string{"txtvers", "rp", "ty", "pdl", "note", "product"}},
+	{PDLDatastream, "Raw printing (port 9100)", 9100, []string{"txtvers", "ty", "pdl", "note", "product"}},
+	{Scanner, "eSCL scanner", 80, []string{"txtvers", "rs", "ty", "pdl", "UUID", "adminurl", "representation", "cs", "is", "duplex"}},
+	{AirPlay, "Apple AirPlay", 7000, []string{"deviceid", "features", "model", "srcvers", "pk", "pi", "flags"}},
+	{RAOP, "AirPlay audio (RAOP)", 7000, []string{"txtvers", "ch", "cn", "et", "sr", "ss", "tp", "vs", "am", "pk"}},
+	{GoogleCast, "Google Cast", 8009, []string{"id", "cd", "md", "fn", "ve", "ca", "ic", "rs", "st", "bs", "nf", "rm"}},
+	{SpotifyConnect, "Spotify Connect", 0, []string{"CPath", "VERSION", "Stack"}},
+	{HomeKit, "HomeKit Accessory Protocol", 0, []string{"c#", "ff", "id", "md", "pv", "s#", "sf", "ci", "sh"}},
+	{Matter, "Matter operational node", 5540, []string{"SII", "SAI", "SAT", "T", "ICD"}},
+	{MatterCommission, "Matter commissionable node", 5540, []string{"D", "CM", "VP", "DT", "DN", "RI", "PH", "PI", "SII", "SAI", "SAT", "T", "ICD"}},
+	{RFB, "VNC remote desktop", 5900, nil},
+	{Workstation, "Workstation", 9, nil},
+	{DeviceInfo, "Device information (no service)", 0, []string{"model", "osxvers"}},
+}
+
+// Lookup returns the Info for a service type. The type may include a trailing
+// domain (e.g. "_http._tcp.local.") and is matched case-insensitively.
+func Lookup(serviceType string) (Info, bool) {
+	serviceType = strings.TrimSuffix(strings.TrimSuffix(serviceType, "."), ".local")
+	for _, info := range registry {
+		if strings.EqualFold(info.Type, serviceType) {
+			info.TXTKeys = slices.Clone(info.TXTKeys)
+			return info, true
+		}
+	}
+	return Info{}, false
+}
+
+// All returns the Info of every known service type.
+func All() []Info {
+	all := slices.Clone(registry)
+	for i := range all {
+		all[i].TXTKeys = slices.Clone(all[i].TXTKeys)
+	}
+	return all
+}
@@ -0,0 +1,137 @@
+package simplemdns
+
+import (
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SpoofEvent reports a unique (cache-flush) record announced by a different
+// source than the one that announced it first, while the first announcement
+// is still within its TTL. This is how trivial LAN spoofing of a host name
+// looks, but also how a host answering from several addresses of one family,
+// or a device replaced within the TTL, look; treat it as a signal.
+type SpoofEvent struct {
+	Record   dns.RR       // the conflicting record
+	Owner    net.IP       // address that first announced the record
+	From     *net.UDPAddr // sender of the conflicting record
+	Rejected bool         // whether the record was dropped (RejectSpoofed)
+}
+
+// spoofMaxTracked bounds the number of tracked records; past it expired ones
+// are pruned, and if that is not enough tracking starts over.
+const spoofMaxTracked = 100_000
+
+// spoofGuard remembers which address first announced each unique record. The
+// owner is tracked per address family, since a host answering over both IPv4
+// and IPv6 legitimately does so from two addresses.
+type spoofGuard struct {
+	onSpoof func(SpoofEvent)
+	reject  bool
+
+	suspected atomic.Uint64
+
+	mu     sync.Mutex
+	owners map[string]spoofOwner // name/type/class/family
+}
+
+type spoofOwner struct {
+	ip      net.IP
+	expires time.Time
+}
+
+func newSpoofGuard(onSpoof func(SpoofEvent), reject bool) *spoofGuard {
+	return &spoofGuard{onSpoof: onSpoof, reject: reject, owners: make(map[string]spoofOwner)}
+}
+
+// check looks for unique records in msg whose owner changed, reporting each
+// and, when rejecting, removing it from msg. It returns false if nothing is
+// left of msg.
+func (g *spoofGuard) check(m *ReceivedMsg) bool {
+	msg, from := m.Msg, m.Src
+	if from == nil || !msg.Response {
+		return true
+	}
+	family := "6"
+	if from.IP.To4() != nil {
+		family = "4"
+	}
+	now := time.Now()
+
+	var events []SpoofEvent
+	g.mu.Lock()
+	msg.Answer = g.checkSection(msg.Answer, from, family, now, &events)
+	msg.Ns = g.checkSection(msg.Ns, from, family, now, &events)
+	msg.Extra = g.checkSection(msg.Extra, from, family, now, &events)
+	g.mu.Unlock()
+
+	for _, e := range events {
+		g.suspected.Add(1)
+		h := e.Record.Header()
+		logger.Warn("unique record announced by a different source",
+			slog.String("name", h.Name),
+			slog.String("type", dns.Type(h.Rrtype).String()),
+			slog.String("owner", e.Owner.String()),
+			slog.String("from", e.From.String()),
+			slog.Bool("rejected", e.Rejected))
+		if g.onSpoof != nil {
+			g.onSpoof(e)
+		}
+	}
+
+	return len(msg.Question)+len(msg.Answer)+len(msg.Ns)+len(msg.Extra) > 0
+}
+
+// checkSection checks the records of one section and returns those to keep.
+// g.mu must be held.
+func (g *spoofGuard) checkSection(rrs []dns.RR, from *net.UDPAddr, family string, now time.Time, events *[]SpoofEvent) []dns.RR {
+	kept := rrs[:0]
+	for _, rr := range rrs {
+		h := rr.Header()
+		if h.Class&cacheFlushBit == 0 {
+			kept = append(kept, rr)
+			continue
+		}
+		key := strings.ToLower(h.Name) + "/" + strconv.Itoa(int(h.Rrtype)) + "/" + strconv.Itoa(int(h.Class&^cacheFlushBit)) + "/" + family
+
+		owner, ok := g.owners[key]
+		if ok && now.Before(owner.expires) && !owner.ip.Equal(from.IP) {
+			*events = append(*events, SpoofEvent{Record: rr, Owner: owner.ip, From: from, Rejected: g.reject})
+			if !g.reject {
+				kept = append(kept, rr)
+			}
+			continue
+		}
+
+		kept = append(kept, rr)
+		if h.Ttl == 0 {
+			// goodbye from the owner (or an expired entry): forget it
+			delete(g.owners, key)
+			continue
+		}
+		if len(g.owners) >= spoofMaxTracked {
+			g.prune(now)
+		}
+		g.owners[key] = spoofOwner{ip: from.IP, expires: now.Add(time.Duration(h.Ttl) * time.Second)}
+	}
+	return kept
+}
+
+// prune drops expired owners, or all of them if none has expired. g.mu must
+// be held.
+func (g *spoofGuard) prune(now time.Time) {
+	for k, o := range g.owners {
+		if !now.Before(o.expires) {
+			delete(g.owners, k)
+		}
+	}
+	if len(g.owners) >= spoofMaxTracked {
+		clear(g.owners)
+	}
+}
@@ -0,0 +1,120 @@
+package simplemdns
+
+import "github.com/oosawy/simplemdns/internal/transport"
+
+// Stats is a snapshot of client counters.
+type Stats struct {
+	TransportDropped  uint64 // received messages dropped because the transport channel was full
+	SubscriberDropped uint64 // messages dropped because a subscriber channel was full
+	InvalidDropped    uint64 // received messages dropped for violating mDNS message rules
+	SendRetries       uint64 // writes retried after a transient error such as ENOBUFS
+	SendFailures      uint64 // per-interface writes that failed, after any retries
+	SpoofSuspected    uint64 // unique records announced by a second source; see SpoofEvent
+	CachedRecords     int    // records in the answer cache, including expired ones not yet pruned
+	AuditDropped      uint64 // records not written to the audit log because its writer fell behind
+
+	// FirstAnswer is the time from sending a QueryFirst or QueryMsg question
+	// to receiving its first answer, for queries that got one.
+	FirstAnswer Histogram
+	// QueryComplete is the time QueryFirst and QueryMsg calls took to return,
+	// whatever the outcome, including time spent waiting for MaxInFlight.
+	QueryComplete Histogram
+	// QueryFailures counts QueryFirst and QueryMsg calls that returned an
+	// error, such as a timeout.
+	QueryFailures uint64
+}
+
+// DropKind tells which channel overflowed when a message was dropped.
+type DropKind int
+
+const (
+	// DropTransport means the transport's receive channel was full.
+	DropTransport DropKind = iota + 1
+	// DropSubscriber means a Subscribe channel was full.
+	DropSubscriber
+	// DropAudit means the audit log fell behind and records of the message
+	// were not logged.
+	DropAudit
+)
+
+func (k DropKind) String() string {
+	switch k {
+	case DropTransport:
+		return "transport"
+	case DropSubscriber:
+		return "subscriber"
+	case DropAudit:
+		return "audit"
+	default:
+		return "unknown"
+	}
+}
+
+// Stats returns a snapshot of the client's counters.
+func (c *client) Stats() Stats {
+	ts := c.t.Stats()
+	return Stats{
+		TransportDropped:  ts.MsgsDropped,
+		SubscriberDropped: c.subDropped.Load(),
+		InvalidDropped:    ts.MsgsInvalid,
+		SendRetries:       ts.SendRetries,
+		SendFailures:      ts.SendFailures,
+		SpoofSuspected:    c.spoofSuspected(),
+		CachedRecords:     c.cachedRecords(),
+		AuditDropped:      c.auditDropped(),
+		FirstAnswer:       c.firstAnswer.snapshot(),
+		QueryComplete:     c.queryComplete.snapshot(),
+		QueryFailures:     c.queryFailures.Load(),
+	}
+}
+
+func (c *client) spoofSuspected() uint64 {
+	if c.spoof == nil {
+		return 0
+	}
+	return c.spoof.suspected.Load()
+}
+
+func (c *client) auditDropped() uint64 {
+	if c.audit == nil {
+		return 0
+	}
+	return c.audit.dropped.Load()
+}
+
+func (c *client) cachedRecords() int {
+	if c.cache == nil {
+		return 0
+	}
+	return c.cache.len()
+}
+
+// Status describes how the client's transport was set up.
+type Status struct {
+	// BindTo is the bind strategy in effect. With BindAuto it tells whether the
+	// mDNS port was obtained (BindMDNSPort) or not (BindZeroAddr).
+	BindTo transport.BindStrategy
+	// IPVersion is the set of families with a working socket. It is 0 when
+	// the client goes through a Broker, which owns the sockets.
+	IPVersion transport.IPVersion
+	// Failed lists the requested families that could not be set up, e.g. IPv6
+	// on a host where it is disabled. NewClient only fails if none could. They
+	// are retried whenever the interfaces change.
+	Failed []*FamilyError
+	// NoGroup is the set of families running without any multicast group
+	// joined, which only happens with BestEffort. Such a family can still
+	// send, on its interfaces or, if it has none, wherever the system routes
+	// multicast, but will likely receive no multicast traffic.
+	NoGroup transport.IPVersion
+}
+
+// Status returns how the client's transport was set up.
+func (c *client) Status() Status {
+	ts := c.t.Status()
+	return Status{
+		BindTo:    ts.BindTo,
+		IPVersion: ts.IPVersion,
+		Failed:    ts.Failed,
+		NoGroup:   ts.NoGroup,
+	}
+}
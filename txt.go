@@ -0,0 +1,188 @@
+package simplemdns
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxTXTStringLen is the maximum length of a single TXT character-string
+	// (RFC 1035 §3.3, one length byte).
+	maxTXTStringLen = 255
+	// maxTXTRecordLen keeps the whole TXT rdata small enough to fit in a single
+	// typical Ethernet packet alongside the other records of a service
+	// (RFC 6763 §6.2).
+	maxTXTRecordLen = 1300
+)
+
+// Errors returned by ValidateTXT.
+var (
+	ErrTXTStringTooLong = errors.New("TXT string exceeds 255 bytes")
+	ErrTXTRecordTooLong = errors.New("TXT record exceeds safe packet size")
+	ErrTXTEmptyKey      = errors.New("TXT key is empty")
+	ErrTXTInvalidKey    = errors.New("TXT key contains invalid characters")
+	ErrTXTDuplicateKey  = errors.New("TXT key is duplicated")
+)
+
+// ValidateTXT checks txt, a list of "key=value" (or bare "key") strings, against
+// the DNS-SD TXT rules of RFC 6763 §6 and returns the first violation found.
+// Keys must be non-empty printable US-ASCII without '=', and are compared
+// case-insensitively for duplicates.
+func ValidateTXT(txt []string) error {
+	var total int
+	seen := make(map[string]struct{}, len(txt))
+	for _, s := range txt {
+		if len(s) > maxTXTStringLen {
+			return fmt.Errorf("%w: %q (%d bytes)", ErrTXTStringTooLong, truncate(s, 32), len(s))
+		}
+		total += 1 + len(s) // length byte + data
+		if s == "" {
+			// a lone empty string is how an empty TXT record is encoded
+			continue
+		}
+
+		key, _, _ := strings.Cut(s, "=")
+		if key == "" {
+			return fmt.Errorf("%w: %q", ErrTXTEmptyKey, truncate(s, 32))
+		}
+		for i := 0; i < len(key); i++ {
+			if key[i] < 0x20 || key[i] > 0x7e {
+				return fmt.Errorf("%w: %q", ErrTXTInvalidKey, key)
+			}
+		}
+
+		lower := strings.ToLower(key)
+		if _, dup := seen[lower]; dup {
+			return fmt.Errorf("%w: %q", ErrTXTDuplicateKey, key)
+		}
+		seen[lower] = struct{}{}
+	}
+
+	if total > maxTXTRecordLen {
+		return fmt.Errorf("%w: %d bytes (limit %d)", ErrTXTRecordTooLong, total, maxTXTRecordLen)
+	}
+
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// TXT is a decoded DNS-SD TXT record (RFC 6763 §6). Keys are stored
+// lower-cased since they are case-insensitive. A nil value means the key was
+// present without '=' (a boolean attribute); a non-nil empty value means
+// "key=" (present with an empty value).
+type TXT map[string][]byte
+
+const txtversKey = "txtvers"
+
+// ParseTXT decodes TXT strings. Strings with an empty key are ignored, and only
+// the first occurrence of a key is kept, as RFC 6763 §6.4 requires.
+func ParseTXT(txt []string) TXT {
+	t := make(TXT, len(txt))
+	for _, s := range txt {
+		key, value, hasValue := strings.Cut(s, "=")
+		if key == "" {
+			continue
+		}
+		key = strings.ToLower(key)
+		if _, dup := t[key]; dup {
+			continue
+		}
+		if hasValue {
+			t[key] = []byte(value)
+		} else {
+			t[key] = nil
+		}
+	}
+	return t
+}
+
+// Has reports whether key is present, with or without a value.
+func (t TXT) Has(key string) bool {
+	_, ok := t[strings.ToLower(key)]
+	return ok
+}
+
+// Get returns the value of key. ok is false if the key is absent or is a
+// boolean attribute without '='.
+func (t TXT) Get(key string) (value string, ok bool) {
+	v, present := t[strings.ToLower(key)]
+	if !present || v == nil {
+		return "", false
+	}
+	return string(v), true
+}
+
+// IsFlag reports whether key is present as a boolean attribute ("key" with no
+// '='). Per RFC 6763 §6.4 the presence of such a key means true.
+func (t TXT) IsFlag(key string) bool {
+	v, present := t[strings.ToLower(key)]
+	return present && v == nil
+}
+
+// Set sets key to value ("key=value"; an empty value encodes as "key=").
+func (t TXT) Set(key, value string) {
+	t[strings.ToLower(key)] = []byte(value)
+}
+
+// SetFlag sets key as a boolean attribute (encoded as a bare "key").
+func (t TXT) SetFlag(key string) {
+	t[strings.ToLower(key)] = nil
+}
+
+// Version returns the value of the txtvers key (RFC 6763 §6.7).
+func (t TXT) Version() (int, bool) {
+	v, ok := t.Get(txtversKey)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// SetVersion sets the txtvers key.
+func (t TXT) SetVersion(v int) {
+	t.Set(txtversKey, strconv.Itoa(v))
+}
+
+// Strings encodes t as TXT strings, with txtvers first as RFC 6763 §6.7
+// recommends and the remaining keys sorted for a stable order. An empty TXT
+// encodes as a single empty string, since a TXT record must not be empty
+// (RFC 6763 §6.1).
+func (t TXT) Strings() []string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		if k != txtversKey {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	if _, ok := t[txtversKey]; ok {
+		keys = slices.Insert(keys, 0, txtversKey)
+	}
+
+	if len(keys) == 0 {
+		return []string{""}
+	}
+
+	txt := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if v := t[k]; v != nil {
+			txt = append(txt, k+"="+string(v))
+		} else {
+			txt = append(txt, k)
+		}
+	}
+	return txt
+}
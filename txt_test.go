@@ -0,0 +1,134 @@
+package simplemdns
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateTXT(t *testing.T) {
+	tests := []struct {
+		name string
+		txt  []string
+		want error
+	}{
+		{"none", nil, nil},
+		{"empty record", []string{""}, nil},
+		{"pairs and flags", []string{"txtvers=1", "path=/", "secure", "note="}, nil},
+		{"value may hold '='", []string{"q=a=b"}, nil},
+		{"value may hold any byte", []string{"bin=\x00\xff"}, nil},
+		{"longest string", []string{"k=" + strings.Repeat("v", 253)}, nil},
+		{"string too long", []string{"k=" + strings.Repeat("v", 254)}, ErrTXTStringTooLong},
+		{"record too long", []string{
+			"a=" + strings.Repeat("v", 250), "b=" + strings.Repeat("v", 250), "c=" + strings.Repeat("v", 250),
+			"d=" + strings.Repeat("v", 250), "e=" + strings.Repeat("v", 250), "f=" + strings.Repeat("v", 50),
+		}, ErrTXTRecordTooLong},
+		{"empty key", []string{"=v"}, ErrTXTEmptyKey},
+		{"control character", []string{"k\x01=v"}, ErrTXTInvalidKey},
+		{"non-ASCII key", []string{"clé=v"}, ErrTXTInvalidKey},
+		{"space allowed", []string{"my key=v"}, nil},
+		{"duplicate", []string{"path=/", "path=/x"}, ErrTXTDuplicateKey},
+		{"duplicate differing in case", []string{"Path=/", "path"}, ErrTXTDuplicateKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTXT(tt.txt)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTXT(t *testing.T) {
+	got := ParseTXT([]string{"txtvers=2", "Path=/a", "path=/b", "secure", "note=", "=orphan", "", "q=a=b"})
+
+	tests := []struct {
+		key   string
+		has   bool
+		value string
+		ok    bool
+		flag  bool
+	}{
+		{"txtvers", true, "2", true, false},
+		{"PATH", true, "/a", true, false}, // first occurrence wins
+		{"secure", true, "", false, true},
+		{"note", true, "", true, false},
+		{"q", true, "a=b", true, false},
+		{"missing", false, "", false, false},
+		{"", false, "", false, false},
+	}
+	for _, tt := range tests {
+		if has := got.Has(tt.key); has != tt.has {
+			t.Errorf("Has(%q) = %v, want %v", tt.key, has, tt.has)
+		}
+		if value, ok := got.Get(tt.key); value != tt.value || ok != tt.ok {
+			t.Errorf("Get(%q) = %q, %v, want %q, %v", tt.key, value, ok, tt.value, tt.ok)
+		}
+		if flag := got.IsFlag(tt.key); flag != tt.flag {
+			t.Errorf("IsFlag(%q) = %v, want %v", tt.key, flag, tt.flag)
+		}
+	}
+	if len(got) != 5 {
+		t.Errorf("got %d keys, want 5: %v", len(got), got)
+	}
+	if v, ok := got.Version(); v != 2 || !ok {
+		t.Errorf("Version() = %d, %v, want 2, true", v, ok)
+	}
+}
+
+func TestTXTVersion(t *testing.T) {
+	tests := []struct {
+		txt  []string
+		want int
+		ok   bool
+	}{
+		{[]string{"txtvers=1"}, 1, true},
+		{[]string{"TXTVERS=3"}, 3, true},
+		{[]string{"txtvers"}, 0, false},
+		{[]string{"txtvers=x"}, 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		if v, ok := ParseTXT(tt.txt).Version(); v != tt.want || ok != tt.ok {
+			t.Errorf("%q: Version() = %d, %v, want %d, %v", tt.txt, v, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTXTStrings(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(TXT)
+		want  []string
+	}{
+		{"empty", func(TXT) {}, []string{""}},
+		{"sorted with txtvers first", func(t TXT) {
+			t.Set("zeta", "1")
+			t.SetFlag("Alpha")
+			t.SetVersion(1)
+			t.Set("note", "")
+		}, []string{"txtvers=1", "alpha", "note=", "zeta=1"}},
+		{"set replaces", func(t TXT) {
+			t.SetFlag("k")
+			t.Set("K", "v")
+		}, []string{"k=v"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txt := make(TXT)
+			tt.build(txt)
+			got := txt.Strings()
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			if err := ValidateTXT(got); err != nil {
+				t.Errorf("encoded record is invalid: %v", err)
+			}
+			if back := ParseTXT(got).Strings(); !slices.Equal(back, got) {
+				t.Errorf("round trip: got %q, want %q", back, got)
+			}
+		})
+	}
+}
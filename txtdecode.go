@@ -0,0 +1,102 @@
+package simplemdns
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// UnmarshalTXT decodes TXT strings into the struct pointed to by v. Each
+// exported field is filled from the key named by its `mdns:"key"` tag, or by
+// the field name if untagged; a tag of "-" skips the field. Keys match
+// case-insensitively and absent keys leave fields untouched.
+//
+// Supported field types are string, []byte, bool, and all integer and float
+// kinds. A bool is true for a bare boolean attribute ("key") or an empty value
+// ("key="), and otherwise parsed with strconv.ParseBool. A []byte is nil for
+// a bare boolean attribute and empty for an empty value.
+func UnmarshalTXT(txt []string, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("UnmarshalTXT: v must be a non-nil pointer to a struct")
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	t := ParseTXT(txt)
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		key := f.Name
+		if tag, ok := f.Tag.Lookup("mdns"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				key = tag
+			}
+		}
+
+		raw, present := t[strings.ToLower(key)]
+		if !present {
+			continue
+		}
+		if err := setTXTField(rv.Field(i), raw); err != nil {
+			return fmt.Errorf("UnmarshalTXT: key %q into field %s: %w", key, f.Name, err)
+		}
+	}
+	return nil
+}
+
+// setTXTField stores raw into field. raw is nil for a bare boolean attribute.
+func setTXTField(field reflect.Value, raw []byte) error {
+	s := string(raw)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %s", field.Type())
+		}
+		if raw == nil {
+			field.SetBytes(nil)
+		} else {
+			field.SetBytes(append([]byte{}, raw...))
+		}
+	case reflect.Bool:
+		if s == "" {
+			field.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
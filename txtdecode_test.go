@@ -0,0 +1,89 @@
+package simplemdns
+
+import (
+	"reflect"
+	"testing"
+)
+
+type txtFields struct {
+	Version  int    `mdns:"txtvers"`
+	Path     string `mdns:"path"`
+	Secure   bool   `mdns:"secure"`
+	Port     uint16 `mdns:"port"`
+	Ratio    float64
+	Blob     []byte `mdns:"blob"`
+	Skipped  string `mdns:"-"`
+	Untagged string
+	hidden   string
+}
+
+func TestUnmarshalTXT(t *testing.T) {
+	tests := []struct {
+		name    string
+		txt     []string
+		want    txtFields
+		wantErr bool
+	}{
+		{"empty", nil, txtFields{}, false},
+		{"tagged", []string{"txtvers=1", "path=/x", "port=8080", "blob=ab"},
+			txtFields{Version: 1, Path: "/x", Port: 8080, Blob: []byte("ab")}, false},
+		{"field names and case", []string{"RATIO=0.5", "untagged=u", "PATH=/y"},
+			txtFields{Ratio: 0.5, Untagged: "u", Path: "/y"}, false},
+		{"bool flag", []string{"secure"}, txtFields{Secure: true}, false},
+		{"bool empty value", []string{"secure="}, txtFields{Secure: true}, false},
+		{"bool false", []string{"secure=false"}, txtFields{}, false},
+		{"bytes flag", []string{"blob"}, txtFields{}, false},
+		{"bytes empty value", []string{"blob="}, txtFields{Blob: []byte{}}, false},
+		{"skipped and unexported", []string{"skipped=s", "-=s", "hidden=h"}, txtFields{}, false},
+		{"first occurrence wins", []string{"path=/a", "path=/b"}, txtFields{Path: "/a"}, false},
+		{"bad int", []string{"txtvers=one"}, txtFields{}, true},
+		{"int out of range", []string{"port=70000"}, txtFields{}, true},
+		{"bad bool", []string{"secure=maybe"}, txtFields{}, true},
+		{"int flag", []string{"port"}, txtFields{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got txtFields
+			err := UnmarshalTXT(tt.txt, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalTXTLeavesAbsentFields(t *testing.T) {
+	got := txtFields{Path: "/default", Port: 80}
+	if err := UnmarshalTXT([]string{"port=81"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Path != "/default" || got.Port != 81 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestUnmarshalTXTBadTarget(t *testing.T) {
+	var s txtFields
+	var nilPtr *txtFields
+	var unsupported struct {
+		M map[string]string `mdns:"m"`
+	}
+	tests := []struct {
+		name string
+		v    any
+		txt  []string
+	}{
+		{"not a pointer", s, nil},
+		{"nil pointer", nilPtr, nil},
+		{"pointer to non-struct", new(int), nil},
+		{"unsupported field", &unsupported, []string{"m=x"}},
+	}
+	for _, tt := range tests {
+		if err := UnmarshalTXT(tt.txt, tt.v); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}